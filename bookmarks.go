@@ -0,0 +1,141 @@
+// Bookmarks kept in a gemtext file
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bookmark is a link in the bookmarks file
+type Bookmark struct {
+	URL   string
+	Title string
+	line  int // Index of the link line in the file
+}
+
+// Bookmarks is a gemtext file of bookmarked links. Lines other than links are
+// kept as they are, so that the file can be organized by hand with headings
+// and text.
+type Bookmarks struct {
+	path  string
+	lines []string
+}
+
+// LoadBookmarks reads the bookmarks file at path. A missing file is not an
+// error, it is created on the first Save.
+func LoadBookmarks(path string) (*Bookmarks, error) {
+	b := &Bookmarks{path: path}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		b.lines = []string{"# Bookmarks", ""}
+		return b, nil
+	}
+	if err != nil {
+		return b, err
+	}
+	b.lines = strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+	return b, nil
+}
+
+// parseBookmark parses a gemtext link line
+func parseBookmark(line string) (entry Bookmark, ok bool) {
+	if !strings.HasPrefix(line, "=>") {
+		return
+	}
+	rest := strings.TrimSpace(strings.TrimSuffix(line[2:], "\r"))
+	if rest == "" {
+		return
+	}
+	entry.URL = rest
+	if i := strings.IndexAny(rest, " \t"); i != -1 {
+		entry.URL = rest[:i]
+		entry.Title = strings.TrimSpace(rest[i:])
+	}
+	return entry, true
+}
+
+// Entries returns the bookmarked links in the order they appear in the file
+func (b *Bookmarks) Entries() (entries []Bookmark) {
+	for i, line := range b.lines {
+		if entry, ok := parseBookmark(line); ok {
+			entry.line = i
+			entries = append(entries, entry)
+		}
+	}
+	return
+}
+
+// Find returns the 1-based index of the bookmark for u, or 0 if u is not
+// bookmarked
+func (b *Bookmarks) Find(u string) int {
+	for i, entry := range b.Entries() {
+		if entry.URL == u {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Add appends a bookmark for u at the end of the file and returns its index
+func (b *Bookmarks) Add(u string, title string) int {
+	line := "=> " + u
+	if title != "" {
+		line += " " + title
+	}
+	b.lines = append(b.lines, line)
+	return len(b.Entries())
+}
+
+// entry returns the bookmark at 1-based index i
+func (b *Bookmarks) entry(i int) (Bookmark, bool) {
+	entries := b.Entries()
+	if i < 1 || i > len(entries) {
+		return Bookmark{}, false
+	}
+	return entries[i-1], true
+}
+
+// Remove removes the bookmark at 1-based index i and returns it
+func (b *Bookmarks) Remove(i int) (Bookmark, bool) {
+	entry, ok := b.entry(i)
+	if ok {
+		b.lines = append(b.lines[:entry.line], b.lines[entry.line+1:]...)
+	}
+	return entry, ok
+}
+
+// Rename sets the title of the bookmark at 1-based index i
+func (b *Bookmarks) Rename(i int, title string) bool {
+	entry, ok := b.entry(i)
+	if ok {
+		b.lines[entry.line] = strings.TrimSpace("=> " + entry.URL + " " + title)
+	}
+	return ok
+}
+
+// Gemtext returns the contents of the bookmarks file
+func (b *Bookmarks) Gemtext() []byte {
+	return []byte(strings.Join(b.lines, "\n") + "\n")
+}
+
+// Save writes the bookmarks file
+func (b *Bookmarks) Save() error {
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.path, b.Gemtext(), 0644)
+}
+
+// loadBookmarks loads the bookmarks file in the data directory, showing an
+// error if it could not be read
+func (c *Client) loadBookmarks() (*Bookmarks, bool) {
+	b, err := LoadBookmarks(filepath.Join(c.dataDir, "bookmarks.gmi"))
+	if err != nil {
+		c.style.ErrorMsg("Unable to read bookmarks: " + err.Error())
+		return b, false
+	}
+	return b, true
+}
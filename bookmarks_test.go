@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseBookmark(t *testing.T) {
+	var tests = []struct {
+		line string
+		res  Bookmark
+		ok   bool
+	}{
+		{"=> gemini://example.org", Bookmark{URL: "gemini://example.org"}, true},
+		{"=>gemini://example.org  Example  capsule\r", Bookmark{URL: "gemini://example.org", Title: "Example  capsule"}, true},
+		{"=>\tgopher://example.org\tGopher hole", Bookmark{URL: "gopher://example.org", Title: "Gopher hole"}, true},
+		{"=>", Bookmark{}, false},
+		{"# Bookmarks", Bookmark{}, false},
+	}
+
+	for _, test := range tests {
+		res, ok := parseBookmark(test.line)
+		if res != test.res || ok != test.ok {
+			t.Errorf("parseBookmark(%q) = %+v, %v, want %+v, %v", test.line, res, ok, test.res, test.ok)
+		}
+	}
+}
+
+func TestBookmarksEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookmarks.gmi")
+	contents := "# Bookmarks\n\n## Capsules\n=> gemini://a.example\n=> gemini://b.example B\n\n## Holes\n=> gopher://c.example C\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBookmarks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i := b.Find("gemini://b.example"); i != 2 {
+		t.Errorf("Find(b) = %d, want 2", i)
+	}
+	if entry, ok := b.Remove(1); !ok || entry.URL != "gemini://a.example" {
+		t.Errorf("Remove(1) = %+v, %v, want gemini://a.example", entry, ok)
+	}
+	if !b.Rename(2, "Hole") {
+		t.Error("Rename(2) = false")
+	}
+	if _, ok := b.Remove(3); ok {
+		t.Error("Remove(3) = true with only 2 bookmarks")
+	}
+	if i := b.Add("spartan://d.example", ""); i != 3 {
+		t.Errorf("Add() = %d, want 3", i)
+	}
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Bookmarks\n\n## Capsules\n=> gemini://b.example B\n\n## Holes\n=> gopher://c.example Hole\n=> spartan://d.example\n"
+	if string(saved) != want {
+		t.Errorf("saved bookmarks = %q, want %q", saved, want)
+	}
+}
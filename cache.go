@@ -0,0 +1,91 @@
+// In-memory cache of fetched pages
+
+package main
+
+import (
+	"container/list"
+	"net/url"
+	"time"
+)
+
+type cachedPage struct {
+	key     string
+	page    *Page
+	fetched time.Time
+}
+
+// PageCache keeps the most recently fetched pages by URL, so that going back
+// to a page does not fetch it again. The least recently used page is evicted
+// when the cache is full, and pages expire after the TTL.
+type PageCache struct {
+	size  int           // Maximum number of pages, caching is off if 0
+	ttl   time.Duration // 0 for pages to never expire
+	order *list.List    // Most recently used first
+	items map[string]*list.Element
+}
+
+// NewPageCache returns an empty cache of at most size pages expiring after
+// ttl
+func NewPageCache(size int, ttl time.Duration) *PageCache {
+	return &PageCache{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached page for key, unless it has expired
+func (pc *PageCache) Get(key string, now time.Time) (*Page, bool) {
+	elem, ok := pc.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*cachedPage)
+	if pc.ttl > 0 && now.Sub(item.fetched) > pc.ttl {
+		pc.remove(elem)
+		return nil, false
+	}
+	pc.order.MoveToFront(elem)
+	return item.page, true
+}
+
+// Put caches page for key, fetched at now
+func (pc *PageCache) Put(key string, page *Page, now time.Time) {
+	if pc.size <= 0 {
+		return
+	}
+	if elem, ok := pc.items[key]; ok {
+		pc.remove(elem)
+	}
+	pc.items[key] = pc.order.PushFront(&cachedPage{key, page, now})
+	for pc.order.Len() > pc.size {
+		pc.remove(pc.order.Back())
+	}
+}
+
+// Remove drops the cached page for key, such as after it was changed
+func (pc *PageCache) Remove(key string) {
+	if elem, ok := pc.items[key]; ok {
+		pc.remove(elem)
+	}
+}
+
+func (pc *PageCache) remove(elem *list.Element) {
+	delete(pc.items, elem.Value.(*cachedPage).key)
+	pc.order.Remove(elem)
+}
+
+// showCached displays the cached copy of the page at u, if there is one, and
+// returns whether it did
+func (c *Client) showCached(u *url.URL) bool {
+	page, ok := c.cache.Get(u.String(), time.Now())
+	if !ok {
+		return false
+	}
+	c.links = make([]string, 0, 100)
+	c.inputLinks = make([]int, 0, 100)
+	c.DisplayPage(page)
+	c.recordVisit(u)
+	return true
+}
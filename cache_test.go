@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPageCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pc := NewPageCache(2, time.Minute)
+	a, b, c := &Page{mediaType: "a"}, &Page{mediaType: "b"}, &Page{mediaType: "c"}
+
+	pc.Put("a", a, now)
+	pc.Put("b", b, now)
+	// Using a makes b the least recently used
+	if page, ok := pc.Get("a", now); !ok || page != a {
+		t.Errorf("Get(a) = %v, %v, want a", page, ok)
+	}
+	pc.Put("c", c, now)
+	if _, ok := pc.Get("b", now); ok {
+		t.Error("Get(b) = ok, want it evicted")
+	}
+	if page, ok := pc.Get("c", now); !ok || page != c {
+		t.Errorf("Get(c) = %v, %v, want c", page, ok)
+	}
+
+	if _, ok := pc.Get("a", now.Add(2*time.Minute)); ok {
+		t.Error("Get(a) = ok after the TTL")
+	}
+	if _, ok := pc.items["a"]; ok {
+		t.Error("expired page was not removed")
+	}
+
+	off := NewPageCache(0, time.Minute)
+	off.Put("a", a, now)
+	if _, ok := off.Get("a", now); ok {
+		t.Error("Get(a) = ok with a cache size of 0")
+	}
+}
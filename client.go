@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"git.sr.ht/~adnano/go-xdg"
 	"github.com/google/shlex"
@@ -23,44 +25,57 @@ type Page struct {
 	mediaType string
 	params    map[string]string
 	u         *url.URL
+	// The response body yet to be read into bodyBytes, which is streamed as
+	// the page is displayed. Closing it aborts the request.
+	body      io.ReadCloser
+	truncated bool   // Whether the body was not read in full
+	savedPath string // Where the body was saved to, if it was
 }
 
-type RedirectInfo struct {
-	history []string
-	// Total length of the history slice (10 if c.MaxRedirects <- 0). We cap it
-	// at 10 to prevent it from infinetely overflowing, effectively we store
-	// only the last 10 redirect URLs, hence user only see those last 10.
-	historyCap int
-	// Number of elems in redir history
-	// that is occupied. Also used as
-	// index.
-	historyLen int
-	// Total number of redirects made. >= historyLen
-	count int
-
-	showHistory func()
-	reset       func()
+// title returns the first heading of a gemtext page, or an empty string if
+// there is none
+func (page *Page) title() string {
+	if page.mediaType != "text/gemini" && page.mediaType != "text/scroll" {
+		return ""
+	}
+	for _, line := range strings.Split(string(page.bodyBytes), "\n") {
+		if strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimLeft(line, "#"))
+		}
+	}
+	return ""
 }
 
 // Client contains all the data for a gelim session
 type Client struct {
-	links            []string
-	inputLinks       []int // contains index to links in `links` that needs spartan input
-	history          []*url.URL
-	conf             *Config
-	configPath       string
-	dataDir          string
-	style            *Style
-	promptSuggestion string
+	links        []string
+	inputLinks   []int      // contains index to links in `links` that needs spartan input
+	linkLines    []int      // Line of each link in the rendered page
+	nav          Navigation // Pages visited in this session
+	savedHistory *History   // Visited URLs across sessions
+	scrollTo     int        // Line to start the pager at for the next page
+	cache        *PageCache
+	// Pages that moved permanently (31)
+	permanentRedirects *PermanentRedirects
+	conf               *Config
+	configPath         string
+	dataDir            string
+	style              *Style
+	promptSuggestion   string
 
 	tourLinks []string // List of links to tour
 	tourNext  int      // The index for link that will be visit next time user uses tour
 
-	lastPage string
+	lastPage    string
+	currentPage *Page
+	tempPath    string // Temporary files of this session, see tempDir
 
-	redir *RedirectInfo // The object itself does not get changed, only attributes in it -- throughout the runtime of gelim
+	redir RedirectChain // Redirects followed by the last request
 
 	clientCert tls.Certificate
+	identities *Identities
+	knownHosts *KnownHosts
+	dialer     *Dialer
 }
 
 func loadClientCert(configPath string) (cert tls.Certificate, err error) {
@@ -101,37 +116,30 @@ func NewClient(configPath string) (*Client, error) {
 		return &c, err
 	}
 	c.clientCert = cert
-	// c.history = make([]*url.URL, 100)
 	c.links = make([]string, 100)
 
-	c.redir = &RedirectInfo{historyCap: conf.MaxRedirects, historyLen: 0}
-	if c.redir.historyCap <= 0 {
-		c.redir.historyCap = 10
-	}
-	c.redir.history = make([]string, c.redir.historyCap)
-	c.redir.showHistory = func() {
-		for i := 0; i < c.redir.historyLen; i++ {
-			fmt.Println(i+1, c.redir.history[i])
-		}
-	}
-	c.redir.reset = func() {
-		// Reset redirects
-		c.redir.count = 0
-		c.redir.historyLen = 0
-
-		// Not initializing new slice with make() so we don't rely too much on GC.
-		// Initial c.redir.historyCap is ideally maintained.
-		for i := range c.redir.history {
-			c.redir.history[i] = ""
-		}
-	}
-	// note that the c.redir.history slice is initialized at HandleURLWrapper
-
 	c.conf = conf
+	c.dialer = NewDialer(conf)
+	c.cache = NewPageCache(conf.CacheSize, time.Duration(conf.CacheTTL)*time.Second)
 	c.lastPage = ""
 
 	c.dataDir = filepath.Join(xdg.DataHome(), "gelim")
 	os.MkdirAll(c.dataDir, 0755)
+
+	c.identities, err = LoadIdentities(filepath.Join(c.configPath, "identities"), filepath.Join(c.dataDir, "identity_scopes"))
+	if err != nil {
+		return &c, err
+	}
+	c.knownHosts, err = LoadKnownHosts(filepath.Join(c.dataDir, "known_hosts"))
+	if err != nil {
+		return &c, err
+	}
+	c.knownHosts.warn = c.style.WarningMsg
+	c.permanentRedirects, err = LoadPermanentRedirects(filepath.Join(c.dataDir, "redirects"))
+	if err != nil {
+		return &c, err
+	}
+	c.savedHistory, err = LoadHistory(filepath.Join(c.dataDir, "history"), conf.HistorySize)
 	return &c, err
 }
 
@@ -147,6 +155,14 @@ func (c *Client) getLiner() (l *ln.State) {
 // QuitClient cleans up opened files and resources, saves history, and calls
 // os.Exit with the given status code
 func (c *Client) QuitClient(code int) {
+	if c.savedHistory != nil && c.savedHistory.size > 0 {
+		if err := c.savedHistory.Prune(); err != nil {
+			c.style.ErrorMsg("Unable to prune history: " + err.Error())
+		}
+	}
+	if c.tempPath != "" {
+		os.RemoveAll(c.tempPath)
+	}
 	os.Exit(code)
 }
 
@@ -167,44 +183,83 @@ func (c *Client) GetLinkFromIndex(i int) (link string, spartanInput bool) {
 	return
 }
 
-// DisplayPage renders a given page object in the client
+// DisplayPage renders a given page object in the client, streaming its body
+// into the pager as it is read
 func (c *Client) DisplayPage(page *Page) {
-	// TODO: proper stream - read the reader and stuff
-	if page.mediaType == "application/octet-stream" {
-		c.lastPage = string(page.bodyBytes)
-		Pager(c.lastPage, c.conf)
+	// Patterns such as */* should not take over the pages gelim renders
+	// itself, unless the type is listed exactly
+	_, exact := c.conf.Handlers[page.mediaType]
+	native := page.mediaType == "text/gemini" || page.mediaType == "text/scroll" || page.mediaType == "gophermap" || page.mediaType == "nex/directory"
+	if command, ok := handlerFor(c.conf.Handlers, page.mediaType); ok && (exact || !native) {
+		c.currentPage = page
+		if err := c.OpenWithHandler(page, command); err != nil {
+			c.style.ErrorMsg("Unable to open " + page.mediaType + " with " + command + ": " + err.Error())
+		}
 		return
 	}
-	if page.mediaType == "nex/directory" {
+	render := c.plainRenderer()
+	center := true
+	switch {
+	case page.mediaType == "application/octet-stream":
+		// Servers may not know the type of a file, so only display it if
+		// it looks like text
+		if !strings.HasPrefix(page.sniff(), "text/") {
+			c.SaveUnsupported(page)
+			return
+		}
+		center = false
+	case page.mediaType == "nex/directory":
 		// The directory listings in Nex is like gemtext except it's all plain
 		// text, only "=>" links are parsed.
-		rendered := c.ParseNexDirectoryPage(page)
-		c.lastPage = rendered
-		Pager(c.lastPage, c.conf)
-		return
-	}
-	if page.mediaType == "gophermap" {
-		rendered := c.ParseGophermap(page)
-		c.lastPage = rendered
-		Pager(c.lastPage, c.conf)
+		render = c.nexDirectoryRenderer(page)
+	case page.mediaType == "gophermap":
+		render = c.gophermapRenderer(page)
+	case !strings.HasPrefix(page.mediaType, "text/"):
+		// text/* content only, others can be opened with handlers
+		c.SaveUnsupported(page)
 		return
+	case page.mediaType == "text/gemini", page.mediaType == "text/scroll":
+		render = c.geminiRenderer(page)
+		center = false
 	}
-	// text/* content only for now
-	// TODO: support more media types
-	if !strings.HasPrefix(page.mediaType, "text/") {
-		c.style.ErrorMsg("Unsupported type " + page.mediaType)
-		return
+	// FIXME: re-center on re-display
+	fetched := page.body != nil
+	rendered, err := c.streamPage(page, render, center)
+	c.lastPage = rendered
+	c.currentPage = page
+	if fetched && err == nil && !page.truncated {
+		c.cache.Put(page.u.String(), page, time.Now())
+	}
+	switch {
+	case err == ErrInterrupted:
+		c.style.WarningMsg("Loading cancelled, the page is incomplete")
+	case err == ErrBodyTooLarge:
+		c.style.WarningMsg(fmt.Sprintf("The page is larger than maxBodySize (%d MiB), the rest was not loaded", c.conf.MaxBodySize))
+	case err != nil:
+		c.style.ErrorMsg("Unable to read body: " + err.Error())
 	}
-	if page.mediaType == "text/gemini" {
-		rendered := c.ParseGeminiPage(page)
-		c.lastPage = rendered
-		Pager(c.lastPage, c.conf)
-		return
+}
+
+// SaveUnsupported saves a page that cannot be displayed to the download
+// directory
+func (c *Client) SaveUnsupported(page *Page) {
+	c.currentPage = page
+	fmt.Println("Unable to display type " + page.mediaType + ", saving it instead")
+	if _, err := c.SavePage(page, ""); err != nil {
+		c.style.ErrorMsg("Unable to save: " + err.Error())
 	}
-	// other text/* stuff
-	c.lastPage = c.Centered(strings.Split(string(page.bodyBytes), "\n"), 0, []int{})
-	// FIXME: re-center on re-display
-	Pager(c.lastPage, c.conf)
+}
+
+// DisplayLocalPage displays gemtext generated by gelim, such as bookmarks,
+// like a gemini page at about:name so that its links can be visited by index
+func (c *Client) DisplayLocalPage(name string, gemtext []byte) {
+	page := &Page{bodyBytes: gemtext, mediaType: "text/gemini", u: &url.URL{Scheme: "about", Opaque: name}}
+	c.links = make([]string, 0, 100)
+	c.inputLinks = make([]int, 0, 100)
+	rendered := c.ParseGeminiPage(page)
+	c.lastPage = rendered
+	c.currentPage = page
+	Pager(rendered, c.conf)
 }
 
 // Centered wraps lines at given width using ansiwrap, then centers content
@@ -224,19 +279,10 @@ func (c *Client) Centered(lines []string, width int, dedents []int) string {
 		}
 	}
 
-	termWidth, _, err := term.GetSize(0)
-	if err != nil {
-		// TODO do something
-		c.style.ErrorMsg("Error getting terminal size")
+	sides, ok := c.centerIndent(width, maxDedent)
+	if !ok {
 		return strings.Join(lines, "\n")
 	}
-	sides := int((termWidth - width) / 2)
-	if width > termWidth {
-		sides = 0
-	}
-	if sides < maxDedent {
-		sides = maxDedent
-	}
 
 	for i, line := range lines {
 		indent := sides
@@ -248,9 +294,44 @@ func (c *Client) Centered(lines []string, width int, dedents []int) string {
 	return strings.Join(lines, "\n")
 }
 
+// centerIndent returns the indentation that centers content of the given
+// width in the terminal, leaving room for lines that hang up to maxDedent
+// columns to the left. ok is false if the terminal size is unknown.
+func (c *Client) centerIndent(width int, maxDedent int) (sides int, ok bool) {
+	termWidth, _, err := term.GetSize(0)
+	if err != nil {
+		// TODO do something
+		c.style.ErrorMsg("Error getting terminal size")
+		return 0, false
+	}
+	sides = int((termWidth - width) / 2)
+	if width > termWidth {
+		sides = 0
+	}
+	if sides < maxDedent {
+		sides = maxDedent
+	}
+	return sides, true
+}
+
 // ParseGeminiPage parses bytes in page in returns a rendered string for the
 // page
 func (c *Client) ParseGeminiPage(page *Page) string {
+	render := c.geminiRenderer(page)
+	// Links are not tracked by line here, see renderLines
+	c.linkLines = nil
+	rendered := []string{}
+	for _, line := range strings.Split(string(page.bodyBytes), "\n") {
+		if r, ok := render(line); ok {
+			rendered = append(rendered, r.text)
+		}
+	}
+	return strings.Join(rendered, "\n")
+}
+
+// geminiRenderer returns a lineRenderer for gemtext documents, which adds
+// links to c.links as they are rendered.
+func (c *Client) geminiRenderer(page *Page) lineRenderer {
 	var (
 		h1Style    = c.style.gmiH1.Sprint
 		h2Style    = c.style.gmiH2.Sprint
@@ -264,7 +345,7 @@ func (c *Client) ParseGeminiPage(page *Page) string {
 	if err != nil {
 		// TODO do something
 		c.style.ErrorMsg("Error getting terminal size")
-		return ""
+		termWidth = 80
 	}
 	width := termWidth
 	sides := 0
@@ -277,17 +358,16 @@ func (c *Client) ParseGeminiPage(page *Page) string {
 	}
 
 	preformatted := false
-	rendered := ""
-	body := string(page.bodyBytes)
-	for _, line := range strings.Split(body, "\n") {
+	return func(line string) (renderedLine, bool) {
 		if strings.HasSuffix(line, "\r") {
 			line = strings.Trim(line, "\r")
 		}
 		if strings.HasPrefix(line, "```") {
 			preformatted = !preformatted
+			return renderedLine{}, false
 
 		} else if preformatted {
-			rendered += strings.Repeat(" ", sides) + preStyle(line) + "\n"
+			return renderedLine{text: strings.Repeat(" ", sides) + preStyle(line)}, true
 
 		} else if strings.HasPrefix(line, "> ") { // not sure if whitespace after > is mandatory for this
 			// appending extra \n here because we want quote blocks to stand out
@@ -296,26 +376,25 @@ func (c *Client) ParseGeminiPage(page *Page) string {
 			// NOT doing this anymore!
 			// (because it looked bad if quotes are continuous)
 			// TODO: remove extra new lines in the end
-			rendered += ansiwrap.GreedyIndent(quoteStyle(line), width+1+sides, 1+sides, 3+sides) + "\n"
+			return renderedLine{text: ansiwrap.GreedyIndent(quoteStyle(line), width+1+sides, 1+sides, 3+sides)}, true
 
 		} else if strings.HasPrefix(line, "* ") { // whitespace after * is mandatory
 			// Using width - 3 because of 3 spaces "   " indent at the start
-			rendered += "   " + ansiwrap.GreedyIndent(strings.Replace(line, "*", "•", 1), width-3+sides, sides, 5+sides) + "\n"
+			return renderedLine{text: "   " + ansiwrap.GreedyIndent(strings.Replace(line, "*", "•", 1), width-3+sides, sides, 5+sides)}, true
 
 		} else if strings.HasPrefix(line, "###") {
-			rendered += ansiwrap.GreedyIndent(h3Style(line), width+sides, sides, sides) + "\n"
+			return renderedLine{text: ansiwrap.GreedyIndent(h3Style(line), width+sides, sides, sides)}, true
 		} else if strings.HasPrefix(line, "##") {
-			rendered += ansiwrap.GreedyIndent(h2Style(line), width+sides, sides, sides) + "\n"
+			return renderedLine{text: ansiwrap.GreedyIndent(h2Style(line), width+sides, sides, sides)}, true
 		} else if strings.HasPrefix(line, "#") { // whitespace after #'s are optional for headings as per spec
-			rendered += ansiwrap.GreedyIndent(h1Style(line), width+sides, sides, sides) + "\n"
+			return renderedLine{text: ansiwrap.GreedyIndent(h1Style(line), width+sides, sides, sides)}, true
 
 		} else if strings.HasPrefix(line, "=>") || (page.u.Scheme == "spartan" && strings.HasPrefix(line, "=:")) {
 			originalLine := line
 			line = strings.TrimSpace(line[2:])
 			if line == "" {
 				// Empty link line
-				rendered += strings.Repeat(" ", sides) + originalLine + "\n"
-				continue
+				return renderedLine{text: strings.Repeat(" ", sides) + originalLine}, true
 			}
 			bits := strings.Fields(line)
 			parsedLink, err := url.Parse(bits[0])
@@ -325,8 +404,7 @@ func (c *Client) ParseGeminiPage(page *Page) string {
 					c.style.StyleSprint(c.style.Error, "invalid link"),
 					bits[0],
 				)
-				rendered += ansiwrap.GreedyIndent(linkLine, width+sides, sides, sides) + "\n"
-				continue
+				return renderedLine{text: ansiwrap.GreedyIndent(linkLine, width+sides, sides, sides)}, true
 			}
 
 			link := page.u.ResolveReference(parsedLink) // link url
@@ -406,18 +484,11 @@ func (c *Client) ParseGeminiPage(page *Page) string {
 			}
 			// XXX: wrap twice for single word
 
-			linkLine = ansiwrap.GreedyIndent(linkLine, width+sides, sides, sides+leftWidth)
-			rendered += linkLine + "\n"
-		} else {
-			// Normal paragraph
-			rendered += ansiwrap.GreedyIndent(line, width+sides, sides, sides) + "\n"
+			return renderedLine{text: ansiwrap.GreedyIndent(linkLine, width+sides, sides, sides+leftWidth)}, true
 		}
+		// Normal paragraph
+		return renderedLine{text: ansiwrap.GreedyIndent(line, width+sides, sides, sides)}, true
 	}
-	// Remove last \n
-	if len(rendered) > 0 {
-		rendered = rendered[:len(rendered)-1]
-	}
-	return rendered
 }
 
 // Input handles Input status codes
@@ -453,20 +524,33 @@ func (c *Client) Input(u string, sensitive bool) (ok bool) {
 	} else {
 		u = u + "?" + queryEscape(query)
 	}
-	return c.HandleURLWrapper(u)
+	return c.HandleURL(u)
 }
 
 // PromptYesNo asks for [y/n]. Return user's choice and whether the prompt was
 // successful (in that order!).
-func (c *Client) PromptYesNo(defaultOpt bool) (opt bool, ok bool) {
-	ok = defaultOpt
+func (c *Client) PromptYesNo() (opt bool, ok bool) {
+	return c.promptYesNo("")
+}
+
+// promptYesNo asks for [y/n] like PromptYesNo, but reads empty input as def
+// if it is "y" or "n".
+func (c *Client) promptYesNo(def string) (opt bool, ok bool) {
+	ok = true
+	prompt := "[y/n]> "
+	switch def {
+	case "y":
+		prompt = "[Y/n]> "
+	case "n":
+		prompt = "[y/N]> "
+	}
 
 	rl := ln.NewLiner()
 	rl.SetCtrlCAborts(true)
 	defer rl.Close()
 
 	for {
-		optStr, err := rl.PromptWithSuggestion("[y/n]> ", "", 1)
+		optStr, err := rl.PromptWithSuggestion(prompt, "", 1)
 
 		if err != nil {
 			opt = false
@@ -482,6 +566,9 @@ func (c *Client) PromptYesNo(defaultOpt bool) (opt bool, ok bool) {
 		}
 
 		optStr = strings.ToLower(optStr)
+		if optStr == "" {
+			optStr = def
+		}
 
 		switch optStr {
 		case "y":
@@ -497,78 +584,42 @@ func (c *Client) PromptYesNo(defaultOpt bool) (opt bool, ok bool) {
 	return
 }
 
-// PromptRedirect asks for input on whether to follow a redirect. Return user's
-// choice and whether the prompt was successful (in that order!).
-func (c *Client) PromptRedirect(nextDest string) (opt bool, ok bool) {
+// PromptString asks for a line of input, pre-filled with suggestion. Return
+// the input and whether the prompt was successful (in that order!).
+func (c *Client) PromptString(prompt string, suggestion string) (input string, ok bool) {
+	rl := ln.NewLiner()
+	rl.SetCtrlCAborts(true)
+	defer rl.Close()
+
+	input, err := rl.PromptWithSuggestion(prompt, suggestion, -1)
+	if err != nil {
+		fmt.Println()
+		if err == ln.ErrPromptAborted || err == io.EOF {
+			c.style.WarningMsg("Cancelled")
+			return "", false
+		}
+		c.style.ErrorMsg("Error reading input: " + err.Error())
+		return "", false
+	}
+	return strings.TrimSpace(input), true
+}
+
+// PromptRedirect asks for input on whether to follow a redirect, with def
+// being the choice on empty input. Return user's choice and whether the
+// prompt was successful (in that order!).
+func (c *Client) PromptRedirect(nextDest string, def bool) (opt bool, ok bool) {
 	if c.conf.ShowRedirectHistory {
-		c.redir.showHistory()
+		c.redir.show()
 		fmt.Println()
 	}
 
 	fmt.Println("Redirect to:")
 	fmt.Println(nextDest)
 
-	opt, ok = c.PromptYesNo(true)
-	return
-}
-
-// RedirectURL handles a redirect by checking MaxRedirects and calling PromptRedirect
-func (c *Client) RedirectURL(u string) (ok bool) {
-	var opt = true
-	var promptCalled = false
-	ok = true
-
-	if c.conf.MaxRedirects == 0 {
-		// Option to prompt for all redirects
-		opt, ok = c.PromptRedirect(u)
-	} else if c.conf.MaxRedirects > 0 && c.conf.MaxRedirects <= c.redir.count {
-		c.style.WarningMsg(fmt.Sprintf("Max redirects of %d reached", c.redir.count))
-		opt, ok = c.PromptRedirect(u)
-		promptCalled = true
-	} // for MaxRedidrects set to negative value, follow all redirects
-
-	if !ok || !opt {
-		return false
-	}
-
-	if promptCalled {
-		// Say max redirects is set to 2. User visits a link. Gets redirected 2
-		// times. gelim prompts whether to follow the next redirect. User
-		// inputs yes. Then gelim must reset the redirects as if user is
-		// visiting a fresh new links, so that the next 2 redirects (if any)
-		// should be handled automatically as before.
-		//
-		// So if the URL was to redirect the user a total of 4 times and max
-		// redirects conf is set to 2, the user will be prompted only 2 times.
-		// Once after first two redirects, another time after the next 2
-		// redirects.
-		c.redir.reset()
-		return c.HandleURL(u)
-	}
-
-	c.redir.count += 1
-	if c.redir.historyLen+1 > len(c.redir.history) && c.conf.MaxRedirects <= 0 {
-		// This should not happen if c.conf.MaxRedirects > 0.
-		//
-		// If 10 redirects are reached we use the rolling window, effectively
-		// c.redir.history will always only contain the 10 MOST RECENT
-		// redirects. Older ones are discarded
-		// XXX: Is this memory safe/efficient?
-		c.redir.history = c.redir.history[1:]
-		c.redir.history = append(c.redir.history, u)
-
-		if c.redir.count >= 20 {
-			// XXX: Can redirects be implmented without recursion?
-			c.style.ErrorMsg("The URL redirected you 20 times. Stack overflow may be reached soon, aborting.")
-			fmt.Println("Here are the", c.redir.historyLen, "most recent redirects.")
-			c.redir.showHistory()
-			return false
-		}
-	} else {
-		c.redir.historyLen += 1
-		c.redir.history[c.redir.historyLen-1] = u // -1 due to 0-indexing
+	if def {
+		return c.promptYesNo("y")
 	}
-	return c.HandleURL(u)
+	return c.promptYesNo("n")
 }
 
 // HandleURL parses the URL, then calls HandleParsedURL. It returns whether it
@@ -580,7 +631,7 @@ func (c *Client) HandleURL(u string) bool {
 		c.style.ErrorMsg("Invalid url")
 		return false
 	}
-	if parsed.Scheme == "" || parsed.Host == "" {
+	if parsed.Scheme == "" || parsed.Host == "" && c.schemeCommand(parsed.Scheme) == "" {
 		// have to parse again
 		parsed, err = url.Parse("gemini://" + u)
 		if err != nil {
@@ -591,276 +642,185 @@ func (c *Client) HandleURL(u string) bool {
 	return c.HandleParsedURL(parsed)
 }
 
-// HandleURLWrapper is like HandleURL but should only be used for the first
-// request
-//
-// It sets c.redir.count and c.redir.historyLen to 0 before calling c.HandleURL
-// with the same argument(s).
-func (c *Client) HandleURLWrapper(u string) bool {
-	c.redir.reset()
-	return c.HandleURL(u)
-}
-
-// Handles either a spartan URL, Nex, or a gemini URL
+// HandleParsedURL fetches parsed with the protocol of its scheme, following
+// redirects, displays the response, and returns whether it was successful.
 func (c *Client) HandleParsedURL(parsed *url.URL) bool {
-	// TODO; config proxies or program to do other shemes
-	if parsed.Scheme == "gemini" {
-		return c.HandleGeminiParsedURL(parsed)
-	}
-	if parsed.Scheme == "spartan" {
-		return c.HandleSpartanParsedURL(parsed)
-	}
-	if parsed.Scheme == "nex" {
-		return c.HandleNexParsedURL(parsed)
-	}
-	if parsed.Scheme == "gopher" {
-		return c.HandleGopherParsedURL(parsed)
-	}
-	c.style.ErrorMsg("Unsupported protocol " + parsed.Scheme)
-	fmt.Println("URL:", parsed)
-	return false
+	c.redir.start(parsed)
+	return c.handleRedirects(parsed, nil)
 }
 
-// HandleSpartanParsedURL makes an requested to parsed URL, displays the page,
-// and returns whether it was successful.
-func (c *Client) HandleSpartanParsedURL(parsed *url.URL) bool {
-	res, err := SpartanParsedURL(parsed)
-	if err != nil {
-		c.style.ErrorMsg(err.Error())
-		return false
-	}
-	defer (*res.conn).Close()
-
-	page := &Page{bodyBytes: nil, mediaType: "", u: parsed, params: nil}
-	// Handle status
-	switch res.status {
-	case 2:
-		mediaType, params, err := ParseMeta(res.meta)
-		if err != nil {
-			c.style.ErrorMsg(fmt.Sprintf("Unable to parse header meta\"%s\": %s", res.meta, err))
-			return false
+// handleRedirects follows the redirects from parsed, whose response is res, or
+// is fetched first if res is nil, then displays the last response and returns
+// whether it was successful. The redirect chain must be started with parsed
+// or a URL that redirected to it.
+func (c *Client) handleRedirects(parsed *url.URL, res *Response) bool {
+	var ok bool
+	for {
+		if res == nil {
+			if parsed, res, ok = c.fetchURL(parsed); res == nil {
+				return ok
+			}
 		}
-		bodyBytes, err := ioutil.ReadAll(res.bodyReader)
-		if err != nil {
-			c.style.ErrorMsg("Unable to read body: " + err.Error())
+		if res.status != StatusRedirect {
+			if res.body != nil {
+				defer res.body.Close()
+			}
+			return c.HandleResponse(parsed, res)
 		}
-		// Only reset links if the page is a success
-		c.links = make([]string, 0, 100) // reset links
-		c.inputLinks = make([]int, 0, 100)
-
-		page.bodyBytes = bodyBytes
-		page.mediaType = mediaType
-		page.params = params
-		c.DisplayPage(page)
-	case 3:
-		return c.RedirectURL("spartan://" + parsed.Host + res.meta)
-	case 4:
-		fmt.Println("Error: " + res.meta)
-	case 5:
-		fmt.Println("Server error: " + res.meta)
-	}
-
-	if (len(c.history) > 0) && (c.history[len(c.history)-1].String() != parsed.String()) || len(c.history) == 0 {
-		c.history = append(c.history, parsed)
+		if parsed, ok = c.nextRedirect(parsed, res); !ok {
+			return false
+		}
+		res = nil
 	}
-	return true
 }
 
-// HandleNexParsedURL makes a request to parsed URL, displays the page, and
-// returns whether it was successful.
-func (c *Client) HandleNexParsedURL(parsed *url.URL) bool {
-	res, err := NexParsedURL(parsed)
-	if err != nil {
-		c.style.ErrorMsg(err.Error())
-		return false
-	}
-	defer (*res.conn).Close()
-
-	page := &Page{bodyBytes: nil, mediaType: "", u: parsed, params: nil}
-	bodyBytes, err := ioutil.ReadAll(res.bodyReader)
-	if err != nil {
-		c.style.ErrorMsg("Unable to read body: " + err.Error())
+// fetchURL fetches parsed with the protocol of its scheme, and returns the URL
+// that was fetched and its response. Schemes with a command in the schemes
+// config are opened with that command instead, with no response, those with a
+// proxyURL are fetched through it, and those with a proxy are fetched from
+// that gemini server. ok is false if an error was printed.
+func (c *Client) fetchURL(parsed *url.URL) (fetched *url.URL, res *Response, ok bool) {
+	if parsed, ok = c.followPermanentRedirect(parsed); !ok {
+		return nil, nil, false
+	}
+	if command := c.schemeCommand(parsed.Scheme); command != "" {
+		if err := c.OpenExternally(parsed, command); err != nil {
+			c.style.ErrorMsg("Unable to open " + parsed.String() + " with " + command + ": " + err.Error())
+			return parsed, nil, false
+		}
+		return parsed, nil, true
 	}
-	// Only reset links if the page is a success
-	c.links = make([]string, 0, 100) // reset links
-	c.inputLinks = make([]int, 0, 100)
-
-	page.bodyBytes = bodyBytes
-
-	// TODO: check file extension
-	if res.fileExt == "/" {
-		page.mediaType = "nex/directory"
-	} else {
-		// Assume plain text for now
-		page.mediaType = "text/plain"
+	if proxyURL := c.conf.Schemes[parsed.Scheme].ProxyURL; proxyURL != "" {
+		proxied, err := proxiedURL(proxyURL, parsed)
+		if err != nil {
+			c.style.ErrorMsg("Invalid proxyURL for " + parsed.Scheme + ": " + err.Error())
+			return parsed, nil, false
+		}
+		if parsed, ok = c.followPermanentRedirect(proxied); !ok {
+			return nil, nil, false
+		}
 	}
-	c.DisplayPage(page)
-
-	if (len(c.history) > 0) && (c.history[len(c.history)-1].String() != parsed.String()) || len(c.history) == 0 {
-		c.history = append(c.history, parsed)
+	protocol, ok := protocols[parsed.Scheme]
+	if scheme := c.conf.Schemes[parsed.Scheme]; scheme.Proxy != "" {
+		protocol, ok = geminiProxy{scheme.Proxy, scheme.ProxyCert}, true
 	}
-	return true
-}
-
-// HandleGopherParsedURL makes a request to parsed URL, displays the page, and
-// returns whether it was successful.
-func (c *Client) HandleGopherParsedURL(parsed *url.URL) bool {
-	res, err := GopherParsedURL(parsed)
-	if err != nil {
-		c.style.ErrorMsg(err.Error())
-		return false
+	if !ok {
+		c.style.ErrorMsg("Unsupported protocol " + parsed.Scheme)
+		fmt.Println("URL:", parsed)
+		fmt.Println("To open these URLs with another program or through a proxy, set a command, proxyURL, or proxy for the scheme in the schemes table of your config.")
+		return parsed, nil, false
 	}
-	defer func() {
-		(*res.conn).Close()
-		res.connClosed = true
-	}()
-
-	page := &Page{bodyBytes: nil, mediaType: "", u: parsed, params: nil}
-	bodyBytes, err := ioutil.ReadAll(res.bodyReader)
+	res, err := c.fetch(protocol, parsed)
 	if err != nil {
-		c.style.ErrorMsg("Unable to read body: " + err.Error())
-	}
-	// Only reset links if the page is a success
-	c.links = make([]string, 0, 100) // reset links
-	c.inputLinks = make([]int, 0, 100)
-
-	page.bodyBytes = bodyBytes
-
-	if res.gophertype == "1" {
-		page.mediaType = "gophermap"
-	} else if res.gophertype == "7" {
-		page.mediaType = "gophermap"
-	} else {
-		// Assume plain text for now
-		page.mediaType = "text/plain"
-	}
-	c.DisplayPage(page)
-
-	if (len(c.history) > 0) && (c.history[len(c.history)-1].String() != parsed.String()) || len(c.history) == 0 {
-		c.history = append(c.history, parsed)
-	}
-	return true
-}
-
-func (c *Client) getClientCert(parsed *url.URL) tls.Certificate {
-	fullURL := parsed.String()
-	for _, urlCheck := range c.conf.UseCertificate {
-		if strings.HasPrefix(fullURL, urlCheck) {
-			return c.clientCert
+		var mismatch *CertMismatchError
+		switch {
+		case errors.As(err, &mismatch):
+			c.CertMismatchWarning(mismatch)
+		case err == ErrInterrupted:
+			c.style.WarningMsg("Cancelled")
+		case err == ErrBodyTooLarge:
+			c.style.ErrorMsg(fmt.Sprintf("The page is larger than maxBodySize (%d MiB)", c.conf.MaxBodySize))
+		default:
+			c.style.ErrorMsg(err.Error())
 		}
+		return parsed, nil, false
 	}
-	return tls.Certificate{}
+	return parsed, res, true
 }
 
-// HandleGeminiParsedURL makes an requested to parsed URL, displays the page,
-// and returns whether it was successful.
-func (c *Client) HandleGeminiParsedURL(parsed *url.URL) bool {
-	res, err := GeminiParsedURL(*parsed, c.getClientCert(parsed))
-	if err != nil {
-		c.style.ErrorMsg(err.Error())
-		return false
+// HandleResponse displays res, the response of any protocol for parsed, and
+// returns whether it was successful. Redirects are followed by HandleParsedURL
+// before this.
+func (c *Client) HandleResponse(parsed *url.URL, res *Response) bool {
+	if res.undefined {
+		c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.code))
 	}
-	defer res.conn.Close()
-
-	// mediaType and params will be parsed later
-	page := &Page{bodyBytes: nil, mediaType: "", u: parsed, params: nil}
-	statusGroup := res.status / 10 // floor division
-	statusRightDigit := res.status - statusGroup*10
-	switch statusGroup {
-	case 1:
-		if statusRightDigit > 1 {
-			c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.status))
-		}
-
-		u := strings.TrimRight(page.u.String(), "?"+page.u.RawQuery)
+	switch res.status {
+	case StatusInput, StatusSensitiveInput:
+		u := *parsed
+		u.RawQuery = ""
 		fmt.Println(res.meta)
-		if res.status == 11 {
-			return c.Input(u, true) // sensitive input
-		}
-		return c.Input(u, false)
-	case 2:
-		if statusRightDigit > 0 {
-			c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.status))
-		}
-
-		mediaType, params, err := ParseMeta(res.meta)
-		if err != nil {
-			c.style.ErrorMsg(fmt.Sprintf("Unable to parse header meta\"%s\": %s", res.meta, err))
-			return false
-		}
-		bodyBytes, err := ioutil.ReadAll(res.bodyReader)
-		if err != nil {
-			c.style.ErrorMsg("Unable to read body: " + err.Error())
-		}
-
+		return c.Input(u.String(), res.status == StatusSensitiveInput)
+	case StatusSuccess:
 		// Only reset links if the page is a success
 		c.links = make([]string, 0, 100) // reset links
 		c.inputLinks = make([]int, 0, 100)
-
-		page.bodyBytes = bodyBytes
-		page.mediaType = mediaType
-		page.params = params
-		c.DisplayPage(page)
-	case 3:
-		if statusRightDigit > 1 {
-			c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.status))
+		c.DisplayPage(&Page{mediaType: res.mediaType, params: res.params, u: parsed, body: res.body})
+		c.recordVisit(parsed)
+	case StatusFailure:
+		c.style.PrintStatus(res.code, res.meta)
+		proxy := c.conf.Schemes[parsed.Scheme].Proxy
+		switch {
+		case res.code == 44:
+			fmt.Println("The server still asks to slow down, try again later.")
+		case res.code == 51:
+			c.suggestSimilar(parsed)
+		case res.code == 52:
+			c.offerBookmarkRemoval(parsed)
+		case res.code == 43 && proxy != "":
+			fmt.Println("The proxy at", proxy, "was unable to fetch the page from", parsed.Host)
+		case res.code == 43:
+			fmt.Println("The server was unable to fetch the page from another server it proxies to.")
+		case res.code == 53 && proxy != "":
+			fmt.Println("The proxy at", proxy, "refused to fetch", parsed.Scheme, "URLs. Check the schemes table in your config.")
+		case res.code == 53:
+			fmt.Println("The server does not serve", parsed.Host, "and refused to proxy the request.")
 		}
-		// TODO: permanent vs temporary redir
-
-		if res.meta == "" {
-			c.style.ErrorMsg(fmt.Sprintf("Redirect status code %d with no redirect URL returned by server.", res.status))
-			return false
-		}
-		return c.RedirectURL(res.meta)
-	case 4, 5:
-		// TODO: use res.meta
-		c.style.WarningMsg("The server responded with an erroneous status:")
-		// switch res.status {
-		// case 40:
-		// 	c.style.ErrorMsg("Temperorary failure")
-		// case 41:
-		// 	c.style.ErrorMsg("Server unavailable")
-		// case 42:
-		// 	c.style.ErrorMsg("CGI error")
-		// case 43:
-		// 	c.style.ErrorMsg("Proxy error")
-		// case 44:
-		// 	c.style.ErrorMsg("Slow down")
-		// case 52:
-		// 	c.style.ErrorMsg("Gone")
-		// }
-		c.style.WarningMsg(fmt.Sprintf("%d %s", res.status, res.meta))
-		if statusGroup == 4 && statusRightDigit > 4 || statusGroup == 5 && (statusRightDigit > 3 && statusRightDigit != 9) {
-			c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.status))
-		}
-
-	case 6:
-		if statusRightDigit > 2 {
-			c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.status))
-		}
-		c.style.WarningMsg("The server has requested a client certificate! This is what it said:")
-		fmt.Println(res.meta)
+	case StatusCertRequired:
+		c.style.PrintStatus(res.code, res.meta)
 		fmt.Println()
-		if c.clientCert.Certificate == nil {
+		if res.code == 60 {
+			fmt.Println("Create a new identity for this URL?")
+			if opt, ok := c.PromptYesNo(); ok && opt {
+				name, ok := c.CreateIdentity(parsed.Hostname())
+				if !ok {
+					return false
+				}
+				scope := identityScope(parsed)
+				c.identities.Activate(name, scope)
+				if err := c.identities.SaveScopes(); err != nil {
+					c.style.ErrorMsg("Unable to save identity scopes: " + err.Error())
+				}
+				fmt.Println("Using identity", name, "for", scope)
+				return c.HandleParsedURL(parsed)
+			}
+		}
+		if c.clientCert.Certificate == nil && len(c.identities.certs) == 0 {
 			c.style.WarningMsg("You have not configured a client certificate with gelim.")
 		}
+		fmt.Println("To use an existing identity for this URL, see `identity ls` and `identity use`. Otherwise:")
 		fmt.Printf("1. Link or save your cert.pem and key.pem files in: %s\n", c.configPath)
 		fmt.Println("2. Use `config edit` to edit your configuration, set `useCertificate = [ ... ]` and include this URL in the list in your config.toml")
 		fmt.Println("3. Reload the new client certificate and configuration using `config reload`")
 	default:
-		c.style.ErrorMsg(fmt.Sprintf("Invalid status code %d", res.status))
-		// return false
-	}
-	if (len(c.history) > 0) && (c.history[len(c.history)-1].String() != parsed.String()) || len(c.history) == 0 {
-		c.history = append(c.history, parsed)
+		c.style.ErrorMsg("Invalid response with no status")
+		return false
 	}
 	return true
 }
 
+// getClientCert returns the client certificate to use for parsed. Named
+// identities take priority over the cert.pem and key.pem in the config
+// directory.
+func (c *Client) getClientCert(parsed *url.URL) tls.Certificate {
+	fullURL := parsed.String()
+	if name, _, _ := c.identities.ActiveFor(fullURL, c.conf.Identities); name != "" {
+		if cert, ok := c.identities.certs[name]; ok {
+			return cert
+		}
+	}
+	for _, urlCheck := range c.conf.UseCertificate {
+		if strings.HasPrefix(fullURL, urlCheck) {
+			return c.clientCert
+		}
+	}
+	return tls.Certificate{}
+}
+
 // Search opens the SearchURL in config with query-escaped query
 func (c *Client) Search(query string) {
 	u := c.conf.SearchURL + "?" + queryEscape(query)
-	c.HandleURLWrapper(u)
+	c.HandleURL(u)
 }
 
 ////// Command stuff //////
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/shlex"
 )
@@ -212,24 +215,49 @@ var commands = map[string]Command{
 	"reload": {
 		aliases: []string{"r"},
 		do: func(c *Client, args ...string) {
-			if len(c.history) < 1 {
+			u := c.currentURL()
+			if u == nil {
 				c.style.ErrorMsg("No history yet!")
 				return
 			}
-			c.HandleParsedURL(c.history[len(c.history)-1])
+			c.HandleParsedURL(u)
 		},
-		help: "re-fetch current page",
+		help: `re-fetch current page
+Pages gone back or forward to are shown from the cache (see the cacheSize and
+cacheTTL config options), reload them to fetch them again.`,
 	},
 	"history": {
 		aliases: []string{"hist", "his"},
 		do: func(c *Client, args ...string) {
-			if len(c.history) == 0 {
+			if len(args) > 0 && (args[0] == "search" || args[0] == "s") {
+				if len(args) < 2 {
+					c.style.ErrorMsg("Search term expected for `search` subcommand")
+					return
+				}
+				if c.savedHistory.size == 0 {
+					c.style.WarningMsg("History is not saved across sessions, see historySize in config")
+				}
+				term := strings.Join(args[1:], " ")
+				results := c.savedHistory.Search(term)
+				if len(results) == 0 {
+					fmt.Println("No history matches", term)
+					return
+				}
+				c.DisplayLocalPage("history", historyGemtext("History matching "+term, results))
+				return
+			}
+			entries := c.nav.entries
+			if len(entries) == 0 {
 				c.style.WarningMsg("No history yet")
 				return
 			}
 			if len(args) == 0 {
-				for i, v := range c.history {
-					fmt.Println(i+1, v.String())
+				for i, v := range entries {
+					if i == c.nav.pos {
+						fmt.Println(i+1, v.u.String(), "(current)")
+						continue
+					}
+					fmt.Println(i+1, v.u.String())
 				}
 				return
 			}
@@ -239,22 +267,33 @@ var commands = map[string]Command{
 				c.style.ErrorMsg("Invalid history index number. Could not convert to integer")
 				return
 			}
-			if index = c.ResolveNonPositiveIndex(index, len(c.history)); index == 0 {
+			if index = c.ResolveNonPositiveIndex(index, len(entries)); index == 0 {
 				return
 			}
-			if len(c.history) < index || index <= 0 {
-				c.style.ErrorMsg(fmt.Sprintf("%d item(s) in history", len(c.history)))
+			if len(entries) < index || index <= 0 {
+				c.style.ErrorMsg(fmt.Sprintf("%d item(s) in history", len(entries)))
 				fmt.Println("Try `history` to view the history")
 				return
 			}
 			// TODO: handle spartan input
-			c.HandleParsedURL(c.history[index-1])
+			c.Navigate(index - 1)
 		},
-		help: `[<index>] : visit an item in history, or print all for current session
+		help: `[<index> | search <term>] : visit an item in history, or print all for current session
+Visiting an item in the history of the current session is like going back or
+forward to it, see ` + "`back`" + `.
+
+Subcommands:
+- s[earch] <term> : search the history of all sessions for URLs and page titles
+                    containing <term>, the results can be visited by link index
+
+History is saved in the data directory, up to historySize entries in your
+config.
+
 Examples:
   - history
   - his 1
-  - hist -3`,
+  - hist -3
+  - history search astrobotany`,
 	},
 	"link": {
 		aliases: []string{"l", "peek", "links"},
@@ -302,31 +341,52 @@ Examples:
 	"back": {
 		aliases: []string{"b"},
 		do: func(c *Client, args ...string) {
-			if len(c.history) < 2 {
+			steps, ok := c.navSteps(args)
+			if !ok {
+				return
+			}
+			if c.nav.pos-steps < 0 {
 				c.style.ErrorMsg("nothing to go back to (try `history` to see history)")
 				return
 			}
-			c.HandleParsedURL(c.history[len(c.history)-2])
-			c.history = c.history[0 : len(c.history)-2]
+			c.Navigate(c.nav.pos - steps)
 		},
-		help: "go back in history",
+		help: `[<n>] : go back n pages in history (default 1)
+The links of the page are restored, and it is scrolled to the link you followed
+from it.
+
+Examples:
+  - back
+  - b 3`,
 	},
 	"forward": {
 		aliases: []string{"f"},
-		do: func(*Client, ...string) {
-			fmt.Println("not implemented yet!")
+		do: func(c *Client, args ...string) {
+			steps, ok := c.navSteps(args)
+			if !ok {
+				return
+			}
+			if c.nav.pos+steps >= len(c.nav.entries) {
+				c.style.ErrorMsg("nothing to go forward to (try `history` to see history)")
+				return
+			}
+			c.Navigate(c.nav.pos + steps)
 		},
-		help:   "go forward in history",
-		hidden: true,
+		help: `[<n>] : go forward n pages in history (default 1)
+Visiting a new page after going back drops the pages you could go forward to.
+
+Examples:
+  - forward
+  - f 2`,
 	},
 	"current": {
 		aliases: []string{"u", "url", "cur"},
 		do: func(c *Client, args ...string) {
-			if len(c.history) == 0 {
+			if c.currentURL() == nil {
 				fmt.Println("No history yet!")
 				return
 			}
-			fmt.Println(c.history[len(c.history)-1])
+			fmt.Println(c.currentURL())
 		},
 		help: "print current url",
 	},
@@ -335,11 +395,11 @@ Examples:
 		do: func(c *Client, args ...string) {
 			var urlStr string
 			if len(args) < 1 {
-				if len(c.history) == 0 {
+				if c.currentURL() == nil {
 					fmt.Println("No history yet!")
 					return
 				}
-				urlStr = c.history[len(c.history)-1].String()
+				urlStr = c.currentURL().String()
 				fmt.Println("url:", urlStr)
 				c.ClipboardCopy(urlStr)
 				return
@@ -395,8 +455,8 @@ to let it handle clipboard copying.
 				}
 				link, _ = c.GetLinkFromIndex(index)
 			} else {
-				if len(c.history) != 0 {
-					link = c.history[len(c.history)-1].String()
+				if c.currentURL() != nil {
+					link = c.currentURL().String()
 				} else {
 					c.style.ErrorMsg("no history yet")
 					return
@@ -419,7 +479,7 @@ to let it handle clipboard copying.
 					fmt.Println("Use `tour go 1` to go back to the beginning")
 					return
 				}
-				c.HandleURLWrapper(c.tourLinks[c.tourNext])
+				c.HandleURL(c.tourLinks[c.tourNext])
 				c.tourNext++
 				return
 			}
@@ -458,7 +518,7 @@ to let it handle clipboard copying.
 					return
 				}
 				// Because user provided number is 1-indexed and tourNext is 0-indexed
-				c.HandleURLWrapper(c.tourLinks[number-1])
+				c.HandleURL(c.tourLinks[number-1])
 				c.tourNext = number
 			case "*", "all":
 				c.tourLinks = append(c.tourLinks, c.links...)
@@ -550,17 +610,18 @@ Examples:
 				fmt.Println("data directory:", c.dataDir)
 				if c.clientCert.Certificate != nil {
 					fmt.Println("client certificate valid and loaded")
+				} else {
+					fmt.Println("no client certificate is found")
 				}
-				fmt.Println("no client certificate is found")
+				fmt.Println(len(c.identities.certs), "identities loaded from", c.identities.dir)
 				return
 			}
 			switch {
 			case strings.HasPrefix("edit", args[0]):
-				editor := os.ExpandEnv("$EDITOR")
 				if _, err := os.Stat(c.configPath); err != nil {
 					fmt.Println("config directory at", c.configPath, "does not exist. gelim is currently using its default configuration.")
 					fmt.Println("create the directory and continue to edit a new config file?")
-					opt, ok := c.PromptYesNo(true)
+					opt, ok := c.PromptYesNo()
 					if !ok || !opt {
 						return
 					}
@@ -573,19 +634,10 @@ Examples:
 				}
 				path := filepath.Join(c.configPath, "config.toml")
 				fmt.Println("opening", path)
-				if editor == "" {
-					c.style.ErrorMsg("no $EDITOR is set!")
-					return
-				}
-				cmd := exec.Command(editor, path)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				cmd.Stdin = os.Stdin
-				if err := cmd.Start(); err != nil {
+				if err := c.OpenEditor(path); err != nil {
 					c.style.ErrorMsg(err.Error())
 					return
 				}
-				cmd.Wait()
 				fmt.Println("you can use `config reload` to reload the updated configuration.")
 				return
 			case strings.HasPrefix("reload", args[0]):
@@ -598,6 +650,9 @@ Examples:
 					return
 				}
 				c.conf = conf
+				c.dialer = NewDialer(conf)
+				c.savedHistory.size = conf.HistorySize
+				c.cache = NewPageCache(conf.CacheSize, time.Duration(conf.CacheTTL)*time.Second)
 				if err != nil {
 					c.style.WarningMsg("file or directory does not exist. default configuration is used")
 				}
@@ -617,6 +672,16 @@ Examples:
 					fmt.Println("client certificate is now active")
 				}
 				fmt.Println("client certificate is reloaded")
+
+				fmt.Println("reloading identities...")
+				ids, err := LoadIdentities(c.identities.dir, c.identities.scopesPath)
+				if err != nil {
+					c.style.ErrorMsg(err.Error())
+					fmt.Println("identities are not reloaded")
+					return
+				}
+				c.identities = ids
+				fmt.Println(len(ids.certs), "identities loaded")
 				return
 			}
 			c.style.ErrorMsg("unknown subcommand for config: " + args[0])
@@ -626,7 +691,43 @@ with no arguments, the currently active config location will be printed.
 
 Subcommands:
 - e[dit]   : opens the currently active config file in $EDITOR
-- r[eload] : re-read and reload an updated config file and client certificate`,
+- r[eload] : re-read and reload an updated config file, client certificate, and identities`,
+	},
+	"save": {
+		aliases: []string{"download", "dl", "w"},
+		do: func(c *Client, args ...string) {
+			page := c.currentPage
+			if page == nil {
+				c.style.ErrorMsg("No page to save yet")
+				return
+			}
+			if page.savedPath != "" && len(args) == 0 {
+				fmt.Println("This page was already saved to", page.savedPath)
+				return
+			}
+			if page.truncated {
+				c.style.WarningMsg("This page was not loaded in full, only the part that was loaded will be saved")
+			}
+			dest := ""
+			if len(args) > 0 {
+				dest = args[0]
+			}
+			if _, err := c.SavePage(page, dest); err != nil {
+				c.style.ErrorMsg("Unable to save: " + err.Error())
+			}
+		},
+		quotedArgs: true,
+		help: `[<path>] : save the current page to a file
+with no arguments, the page is saved in the download directory (see the
+downloadDir config option) with a name picked from the URL and media type.
+If <path> is a directory, the page is saved in there instead.
+
+Pages that gelim is unable to display are saved automatically.
+
+Examples:
+  - save
+  - save ~/notes.gmi
+  - save /tmp`,
 	},
 	"page": {
 		aliases: []string{"p", "print", "view", "display"},
@@ -642,18 +743,543 @@ Subcommands:
 	"redirects": {
 		aliases: []string{"redir", "redirstack", "redirect"},
 		do: func(c *Client, args ...string) {
-			if c.redir.count > 0 {
-				// Should be synced with that from PromptRedirect
-				if c.redir.count > c.redir.historyLen {
-					fmt.Println("Showing the last", c.redir.historyLen, "redirects:")
+			if len(args) == 0 {
+				if c.redir.redirects() > 0 {
+					c.redir.show()
+				} else {
+					fmt.Println("No redirects")
 				}
-				c.redir.showHistory()
+				return
+			}
+			sources := c.permanentRedirects.Sources()
+			switch args[0] {
+			case "moved", "ls":
+				if len(sources) == 0 {
+					fmt.Println("No pages have moved permanently")
+					return
+				}
+				for i, from := range sources {
+					fmt.Printf("%d %s -> %s\n", i+1, from, c.permanentRedirects.targets[from])
+				}
+			case "forget", "rm":
+				if len(args) < 2 {
+					c.style.ErrorMsg("Index expected for `forget` subcommand")
+					fmt.Println("Use `redirects moved` to list them")
+					return
+				}
+				index, err := strconv.Atoi(args[1])
+				if err != nil || index < 1 || index > len(sources) {
+					c.style.ErrorMsg(fmt.Sprintf("%d permanent redirect(s), see `redirects moved`", len(sources)))
+					return
+				}
+				if _, err := c.permanentRedirects.Remove(sources[index-1]); err != nil {
+					c.style.ErrorMsg("Unable to save redirects: " + err.Error())
+					return
+				}
+				fmt.Println("Forgot that", sources[index-1], "moved")
+			case "rewrite":
+				if len(sources) == 0 {
+					fmt.Println("No pages have moved permanently")
+					return
+				}
+				c.RewriteMoved()
+			default:
+				c.style.ErrorMsg("Unknown subcommand " + args[0])
+				fmt.Println("See `help redirects`")
+			}
+		},
+		help: `[moved | forget <index> | rewrite] : view the redirects that led to current page (if any)
+Pages that moved permanently (status 31) are remembered, and later visits to
+their old URL go to the new one directly.
+
+Subcommands:
+- moved, ls          : list the pages that moved permanently
+- forget, rm <index> : forget that a page moved
+- rewrite            : replace the old URLs of moved pages in the bookmarks,
+                       the history, and the tour list
+
+Examples:
+  - redirects
+  - redir moved
+  - redir forget 2
+  - redirects rewrite`,
+	},
+	"certs": {
+		aliases: []string{"cert", "tofu", "knownhosts"},
+		do: func(c *Client, args ...string) {
+			if len(args) == 0 || args[0] == "ls" || args[0] == "l" || args[0] == "list" {
+				entries := c.knownHosts.Sorted()
+				if len(entries) == 0 {
+					fmt.Println("No pinned certificates yet")
+					return
+				}
+				for i, entry := range entries {
+					fmt.Printf("%d %s\n  %s (expires %s)\n", i+1, entry.host, entry.fingerprint, entry.expiry.Format("2006-01-02"))
+				}
+				return
+			}
+			u := &url.URL{}
+			if len(args) > 1 {
+				u.Host = args[1]
+			} else if c.currentURL() != nil {
+				u = c.currentURL()
 			} else {
-				fmt.Println("No redirects")
+				c.style.ErrorMsg("No history yet, please specify a host")
+				return
+			}
+			host := u.Host
+			if u.Port() == "" {
+				host += ":1965"
+			}
+			switch args[0] {
+			case "trust", "t":
+				if !c.knownHosts.Trust(host) {
+					c.style.ErrorMsg("No new certificate was presented by " + host)
+					fmt.Println("Visit the host first, then trust its new certificate if you are sure it is genuine.")
+					return
+				}
+				fmt.Println("Trusted the new certificate for", host)
+			case "forget", "rm", "f":
+				if !c.knownHosts.Forget(host) {
+					c.style.ErrorMsg("No certificate is pinned for " + host)
+					return
+				}
+				fmt.Println("Forgot the pinned certificate for", host)
+				fmt.Println("The next certificate presented by this host will be trusted.")
+			default:
+				c.style.ErrorMsg("unknown subcommand for certs: " + args[0])
+				return
+			}
+			if err := c.knownHosts.Save(); err != nil {
+				c.style.ErrorMsg("Unable to save known hosts: " + err.Error())
 			}
 		},
-		help: "view the redirects that led to current page (if any)",
+		help: `[ ls | trust | forget ] [<host>] : manage certificates pinned on first use
+with no arguments, list the pinned certificates for all hosts.
+
+Subcommands (<host> defaults to the host of the current URL):
+- l[s]            : list pinned certificates
+- t[rust] <host>  : trust the new certificate a host presented on the last visit
+- f[orget] <host> : remove the pinned certificate for a host
+
+Examples:
+  - certs
+  - certs trust example.org
+  - certs forget example.org:1966`,
 	},
+	"bookmarks": {
+		aliases: []string{"bm", "bookmark", "mark"},
+		do: func(c *Client, args ...string) {
+			b, ok := c.loadBookmarks()
+			if !ok {
+				return
+			}
+			if len(args) == 0 {
+				c.DisplayLocalPage("bookmarks", b.Gemtext())
+				return
+			}
+			if index, err := strconv.Atoi(args[0]); err == nil {
+				entries := b.Entries()
+				if index = c.ResolveNonPositiveIndex(index, len(entries)); index == 0 {
+					return
+				}
+				entry, ok := b.entry(index)
+				if !ok {
+					c.style.ErrorMsg(fmt.Sprintf("%d bookmark(s) in total", len(entries)))
+					return
+				}
+				c.HandleURL(entry.URL)
+				return
+			}
+			switch args[0] {
+			case "ls", "list", "l":
+				entries := b.Entries()
+				if len(entries) == 0 {
+					fmt.Println("No bookmarks yet")
+					return
+				}
+				for i, entry := range entries {
+					if entry.Title == "" {
+						fmt.Println(i+1, entry.URL)
+						continue
+					}
+					fmt.Printf("%d %s\n  %s\n", i+1, entry.Title, entry.URL)
+				}
+				return
+			case "add", "a":
+				var link, title string
+				if len(args) > 1 && args[1] == "." {
+					// The current page, so that titles can start with a number
+					args = args[1:]
+				} else if len(args) > 1 {
+					if index, err := strconv.Atoi(args[1]); err == nil {
+						if index = c.ResolveNonPositiveIndex(index, len(c.links)); index == 0 {
+							return
+						}
+						if link, _ = c.GetLinkFromIndex(index); link == "" {
+							return
+						}
+						args = args[1:]
+					}
+				}
+				if link == "" {
+					if c.currentURL() == nil {
+						c.style.ErrorMsg("No history yet, visit a URL to bookmark it")
+						return
+					}
+					link = c.currentURL().String()
+					if c.currentPage != nil && c.currentPage.u.String() == link {
+						title = c.currentPage.title()
+					}
+				}
+				if len(args) > 1 {
+					title = strings.Join(args[1:], " ")
+				}
+				if i := b.Find(link); i != 0 {
+					c.style.WarningMsg(fmt.Sprintf("%s is already bookmarked (%d)", link, i))
+					return
+				}
+				fmt.Printf("Bookmarked %s (%d)\n", link, b.Add(link, title))
+			case "remove", "rm", "r":
+				if len(args) < 2 {
+					c.style.ErrorMsg("Bookmark index expected for `remove` subcommand")
+					return
+				}
+				index, err := strconv.Atoi(args[1])
+				if err != nil {
+					c.style.ErrorMsg(args[1] + ": Invalid bookmark index")
+					return
+				}
+				if index = c.ResolveNonPositiveIndex(index, len(b.Entries())); index == 0 {
+					return
+				}
+				entry, ok := b.Remove(index)
+				if !ok {
+					c.style.ErrorMsg(args[1] + ": Invalid bookmark index")
+					return
+				}
+				fmt.Println("Removed bookmark for", entry.URL)
+			case "rename", "mv":
+				if len(args) < 3 {
+					c.style.ErrorMsg("Bookmark index and new title expected for `rename` subcommand")
+					return
+				}
+				index, err := strconv.Atoi(args[1])
+				if err == nil {
+					index = c.ResolveNonPositiveIndex(index, len(b.Entries()))
+				}
+				if err != nil || !b.Rename(index, strings.Join(args[2:], " ")) {
+					c.style.ErrorMsg(args[1] + ": Invalid bookmark index")
+					return
+				}
+				fmt.Println("Renamed bookmark", index)
+			default:
+				c.style.ErrorMsg("unknown subcommand for bookmarks: " + args[0])
+				return
+			}
+			if err := b.Save(); err != nil {
+				c.style.ErrorMsg("Unable to save bookmarks: " + err.Error())
+			}
+		},
+		help: `[<index> | ls | add | remove | rename] : manage and visit bookmarks
+with no arguments, display the bookmarks page, whose links can be visited by
+index like any other page.
+
+Subcommands:
+- <index>                  : visit a bookmark
+- l[s]                     : list bookmarks
+- a[dd] [<link>] [<title>] : bookmark the current URL, or a link index on the page
+- r[emove] <index>         : remove a bookmark
+- rename <index> <title>   : change the title of a bookmark
+
+A number after add is always a link index. Use . as the link to bookmark the
+current URL with a title that starts with a number.
+
+Bookmarks are kept in bookmarks.gmi in the data directory, which can also be
+edited by hand.
+
+Examples:
+  - bookmarks
+  - bm 2
+  - bm add
+  - bm add 3 Cool capsule
+  - bm add . 2024 Notes
+  - bm rename 1 My capsule
+  - bm rm -1`,
+	},
+	"titanedit": {
+		aliases: []string{"te"},
+		do: func(c *Client, args ...string) {
+			page := c.currentPage
+			u := c.currentURL()
+			if page == nil || u == nil || page.u.String() != u.String() {
+				c.style.ErrorMsg("No page to edit, visit a gemini page first")
+				return
+			}
+			if u.Scheme != "gemini" {
+				c.style.ErrorMsg("Only gemini pages can be edited, by uploading them with Titan")
+				return
+			}
+			if !strings.HasPrefix(page.mediaType, "text/") {
+				c.style.ErrorMsg("Unable to edit pages of type " + page.mediaType)
+				return
+			}
+			if page.truncated {
+				c.style.ErrorMsg("This page was not loaded in full, reload it before editing")
+				return
+			}
+			c.EditPage(page)
+		},
+		help: `edit the current page in $EDITOR and upload it with Titan
+The changes are shown before they are uploaded, once confirmed. The page is
+uploaded to the titan:// URL of the current page, with the client certificate
+and token that would be used by ` + "`upload`" + `.
+
+To edit the current URL instead, see ` + "`editurl`" + `.`,
+	},
+	"upload": {
+		aliases: []string{"up", "put", "titan"},
+		do: func(c *Client, args ...string) {
+			target := c.currentURL()
+			if len(args) > 1 {
+				if index, err := strconv.Atoi(args[1]); err == nil {
+					// A link on the current page, such as a spartan input link
+					link, _ := c.GetLinkFromIndex(index)
+					if link == "" {
+						return
+					}
+					args[1] = link
+				}
+				parsed, err := url.Parse(args[1])
+				if err != nil {
+					c.style.ErrorMsg("Invalid url")
+					return
+				}
+				if target != nil {
+					parsed = target.ResolveReference(parsed)
+				}
+				target = parsed
+			}
+			if target == nil || target.Host == "" {
+				c.style.ErrorMsg("No history yet, please specify a URL to upload to")
+				return
+			}
+
+			path := ""
+			fromEditor := len(args) == 0 || args[0] == "-"
+			if !fromEditor {
+				path = expandPath(args[0])
+			} else {
+				dir, err := c.tempDir()
+				if err != nil {
+					c.style.ErrorMsg(err.Error())
+					return
+				}
+				path = uniquePath(filepath.Join(dir, "upload.gmi"))
+				if err := c.OpenEditor(path); err != nil {
+					c.style.ErrorMsg(err.Error())
+					return
+				}
+			}
+			f, err := os.Open(path)
+			if fromEditor && os.IsNotExist(err) {
+				c.style.WarningMsg("Nothing was written, not uploading")
+				return
+			}
+			if err != nil {
+				c.style.ErrorMsg(err.Error())
+				return
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				c.style.ErrorMsg(err.Error())
+				return
+			}
+			if target.Scheme == "spartan" {
+				c.SpartanUpload(target, f, info.Size())
+				return
+			}
+			if info.Size() == 0 {
+				fmt.Println("The file is empty, which deletes the page on some servers. Upload it anyway?")
+				if opt, ok := c.PromptYesNo(); !ok || !opt {
+					return
+				}
+			}
+			c.Upload(target, f, info.Size(), mediaTypeByExtension(path))
+		},
+		quotedArgs: true,
+		help: `[<file> | -] [<url> | <index>] : upload a file, or text written in $EDITOR, with Titan or Spartan
+with no <file>, or with -, $EDITOR is opened to write a gemtext page to upload.
+<url> defaults to the current URL, and can be a titan:// URL, or the gemini://
+URL of the page to replace. The page returned by the server is then visited.
+With a link index, the link of the current page is used as the URL.
+
+For spartan:// URLs, the file is sent as the data block of the request, like
+the input of a spartan input link.
+
+Tokens can be given in titan:// URLs (titan://host/path;token=secret), or set
+per host in the titanTokens table in your config. Client certificates are used
+like for the gemini:// URL, see ` + "`identity`" + `.
+
+Examples:
+  - upload
+  - upload notes.gmi
+  - upload ~/photo.jpg gemini://example.org/photos/cat.jpg
+  - upload - titan://example.org/new.gmi;token=secret
+  - upload message.txt 3`,
+	},
+	"scrollmeta": {
+		aliases: []string{"sm"},
+		do: func(c *Client, args ...string) {
+			target := c.currentURL()
+			if len(args) > 0 {
+				if index, err := strconv.Atoi(args[0]); err == nil {
+					link, _ := c.GetLinkFromIndex(index)
+					if link == "" {
+						return
+					}
+					args[0] = link
+				}
+				parsed, err := url.Parse(args[0])
+				if err != nil {
+					c.style.ErrorMsg("Invalid url")
+					return
+				}
+				if target != nil {
+					parsed = target.ResolveReference(parsed)
+				}
+				target = parsed
+			}
+			if target == nil || target.Host == "" {
+				c.style.ErrorMsg("No history yet, please specify a scroll:// URL")
+				return
+			}
+			c.ScrollMetadata(target)
+		},
+		help: `[<url> | <index>] : show the metadata of a scroll document, without fetching it
+<url> defaults to the current URL. With a link index, the link of the current
+page is used as the URL. The metadata is requested in the languages of
+scrollLanguages in your config.
+
+Examples:
+  - scrollmeta
+  - scrollmeta 3
+  - sm scroll://example.org/doc.scroll`,
+	},
+	"identity": {
+		aliases: []string{"id", "ident", "identities"},
+		do: func(c *Client, args ...string) {
+			var current string
+			if c.currentURL() != nil {
+				current = c.currentURL().String()
+			}
+			if len(args) == 0 || args[0] == "ls" || args[0] == "l" || args[0] == "list" {
+				names := c.identities.Names()
+				if len(names) == 0 {
+					fmt.Println("No identities in", c.identities.dir)
+					return
+				}
+				active, _, _ := c.identities.ActiveFor(current, c.conf.Identities)
+				for _, name := range names {
+					marker := " "
+					if name == active && current != "" {
+						marker = "*"
+					}
+					fmt.Println(marker, name)
+					for prefix, n := range c.conf.Identities {
+						if n == name {
+							fmt.Println("   ", prefix, "(config)")
+						}
+					}
+					for prefix, n := range c.identities.scopes {
+						if n == name {
+							fmt.Println("   ", prefix)
+						}
+					}
+				}
+				return
+			}
+			if args[0] == "new" || args[0] == "n" {
+				suggestion := ""
+				if len(args) > 1 {
+					suggestion = args[1]
+				}
+				c.CreateIdentity(suggestion)
+				return
+			}
+			if current == "" {
+				c.style.ErrorMsg("No history yet, visit a URL to (de)activate an identity on")
+				return
+			}
+			switch args[0] {
+			case "use", "u", "activate":
+				if len(args) < 2 {
+					c.style.ErrorMsg("Identity name expected for `use` subcommand")
+					fmt.Println("Use `identity ls` to list identities")
+					return
+				}
+				scope := identityScope(c.currentURL())
+				if !c.identities.Activate(args[1], scope) {
+					c.style.ErrorMsg("No such identity: " + args[1])
+					return
+				}
+				fmt.Println("Using identity", args[1], "for", scope)
+			case "off", "o", "deactivate":
+				if c.identities.Deactivate(current) == 0 {
+					if _, prefix, fromConfig := c.identities.ActiveFor(current, c.conf.Identities); fromConfig {
+						c.style.ErrorMsg("The identity for this URL is set in your config for " + prefix)
+						fmt.Println("Use `config edit` to remove it from the identities table")
+					} else {
+						c.style.ErrorMsg("No identity is active for this URL")
+					}
+					return
+				}
+				fmt.Println("Identity deactivated for", current)
+			default:
+				c.style.ErrorMsg("unknown subcommand for identity: " + args[0])
+				return
+			}
+			if err := c.identities.SaveScopes(); err != nil {
+				c.style.ErrorMsg("Unable to save identity scopes: " + err.Error())
+			}
+		},
+		help: `[ ls | new | use <name> | off ] : manage client certificate identities
+with no arguments, list identities and the URL prefixes they are used on. An
+asterisk marks the identity active for the current URL.
+
+Subcommands:
+- l[s]       : list identities
+- n[ew]      : create a new identity with a self-signed certificate
+- u[se] NAME : use an identity for the current URL and URLs under it
+- o[ff]      : stop using identities for the current URL
+
+Identities are read from the identities directory in your config directory,
+one sub-directory containing cert.pem and key.pem per identity. They can also
+be scoped to URL prefixes from the identities table in your config.
+
+Examples:
+  - identity
+  - identity new alice
+  - id use alice
+  - id off`,
+	},
+}
+
+// OpenEditor opens path in $EDITOR and waits for the editor to exit
+func (c *Client) OpenEditor(path string) error {
+	editor := os.ExpandEnv("$EDITOR")
+	if editor == "" {
+		return errors.New("no $EDITOR is set!")
+	}
+	cmd := exec.Command(editor, path)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Wait()
 }
 
 // CommandCompleter returns a suitable command to complete an input line
@@ -20,8 +20,21 @@ type Config struct {
 	SearchURL           string
 	Index0Shortcut      int
 	MaxWidth            int
+	MaxBodySize         int // MiB
+	ConnectTimeout      int // Seconds
+	ReadTimeout         int // Seconds
+	DownloadDir         string
+	HistorySize         int
+	CacheSize           int // Pages
+	CacheTTL            int // Seconds
 	ClipboardCopyCmd    string
 	UseCertificate      []string
+	Identities          map[string]string // URL prefix to identity name
+	Handlers            map[string]string // Media type pattern to command
+	TitanTokens         map[string]string // Host to token for Titan uploads
+	ScrollLanguages     []string          // Preferred languages for scroll documents
+	Schemes             map[string]SchemeConfig
+	SocksProxies        map[string]string // Host pattern to host:port of a SOCKS5 proxy
 }
 
 // LoadConfig opens the specified configuration file if exists and returns a
@@ -39,7 +52,18 @@ func LoadConfig(path string) (*Config, error) {
 	conf.LessOpts = "-FSXr~ -P pager (q to quit)"
 	conf.SearchURL = "gemini://kennedy.gemi.dev/search"
 	conf.MaxWidth = 70
+	conf.MaxBodySize = 32
+	conf.ConnectTimeout = 15
+	conf.ReadTimeout = 30
+	conf.DownloadDir = os.Getenv("XDG_DOWNLOAD_DIR")
+	if conf.DownloadDir == "" {
+		conf.DownloadDir = "~/Downloads"
+	}
+	conf.HistorySize = 1000
+	conf.CacheSize = 20
+	conf.CacheTTL = 600
 	conf.ClipboardCopyCmd = ""
+	conf.ScrollLanguages = []string{"en"}
 
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
@@ -55,6 +79,9 @@ func LoadConfig(path string) (*Config, error) {
 		if _, err = toml.Decode(string(contents), &conf); err != nil {
 			return nil, err
 		}
+		if err = checkSchemes(conf.Schemes); err != nil {
+			return nil, err
+		}
 	}
 
 	return &conf, nil
@@ -62,8 +89,8 @@ func LoadConfig(path string) (*Config, error) {
 
 func (c *Client) parsePrompt() string {
 	var u *url.URL
-	if len(c.history) != 0 {
-		u = c.history[len(c.history)-1]
+	if c.currentURL() != nil {
+		u = c.currentURL()
 	}
 	return BuildPrompt(u, c.conf.Prompt)
 }
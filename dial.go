@@ -0,0 +1,296 @@
+// Connections with timeouts, shared by all protocols
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Phases of a request, used to report where a request stalled
+const (
+	PhaseDNS     = "DNS lookup"
+	PhaseConnect = "connect"
+	PhaseTLS     = "TLS handshake"
+	PhaseHeader  = "response header"
+	PhaseBody    = "response body"
+)
+
+// Config options for each timeout, to point the user to
+const (
+	optConnectTimeout = "connectTimeout"
+	optReadTimeout    = "readTimeout"
+)
+
+// TimeoutError is returned when a phase of a request stalled for longer than
+// its configured timeout
+type TimeoutError struct {
+	Phase    string
+	Duration time.Duration
+	Option   string // Config option for this timeout
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for %s (see %s in config)", e.Duration, e.Phase, e.Option)
+}
+
+// Timeout is for compatibility with net.Error
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Dialer opens connections for every protocol with the configured timeouts
+type Dialer struct {
+	// Time allowed for each of the DNS lookup, connecting, and the TLS
+	// handshake
+	ConnectTimeout time.Duration
+	// Time allowed between reads of the response
+	ReadTimeout time.Duration
+	// Host patterns to the SOCKS5 proxy ("host:port") to connect through
+	SocksProxies map[string]string
+
+	intr *interrupt // Set by Interruptible
+}
+
+// interrupt cancels the lookups and dials of a Dialer, and closes the
+// connections it opened
+type interrupt struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	conns  []io.Closer
+}
+
+// track closes conn once the dialer is interrupted, or right away if it
+// already was
+func (i *interrupt) track(conn io.Closer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.ctx.Err() != nil {
+		conn.Close()
+		return
+	}
+	i.conns = append(i.conns, conn)
+}
+
+func (i *interrupt) stop() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cancel()
+	for _, conn := range i.conns {
+		conn.Close()
+	}
+}
+
+// Interruptible returns a copy of d whose lookups, dials, and connections are
+// stopped when the user presses Ctrl-C, until the returned stop function is
+// called. Connections opened before stop is called are left open by it. stop
+// returns whether the user pressed Ctrl-C.
+func (d *Dialer) Interruptible() (*Dialer, func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	intr := &interrupt{ctx: ctx, cancel: cancel}
+	copied := *d
+	copied.intr = intr
+
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			intr.stop()
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return &copied, func() bool {
+		signal.Stop(sig)
+		close(done)
+		return <-interrupted
+	}
+}
+
+// NewDialer returns a Dialer using the timeouts in conf
+func NewDialer(conf *Config) *Dialer {
+	return &Dialer{
+		ConnectTimeout: time.Duration(conf.ConnectTimeout) * time.Second,
+		ReadTimeout:    time.Duration(conf.ReadTimeout) * time.Second,
+		SocksProxies:   conf.SocksProxies,
+	}
+}
+
+// Conn is a connection where each read times out after the timeout of the
+// current phase. A stalled read returns a *TimeoutError.
+type Conn struct {
+	net.Conn
+	phase   string
+	timeout time.Duration
+	option  string
+}
+
+// SetPhase sets the phase that following reads and writes belong to
+func (c *Conn) SetPhase(phase string, timeout time.Duration, option string) {
+	c.phase, c.timeout, c.option = phase, timeout, option
+}
+
+func (c *Conn) Read(p []byte) (n int, err error) {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+	n, err = c.Conn.Read(p)
+	return n, c.wrapErr(err)
+}
+
+func (c *Conn) Write(p []byte) (n int, err error) {
+	if c.timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	n, err = c.Conn.Write(p)
+	return n, c.wrapErr(err)
+}
+
+func (c *Conn) wrapErr(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &TimeoutError{c.phase, c.timeout, c.option}
+	}
+	return err
+}
+
+// lookup resolves hostname to its addresses, within the connect timeout
+func (d *Dialer) lookup(hostname string) ([]string, error) {
+	if net.ParseIP(hostname) != nil {
+		return []string{hostname}, nil
+	}
+	ctx, cancel := d.context()
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, hostname)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, &TimeoutError{PhaseDNS, d.ConnectTimeout, optConnectTimeout}
+	}
+	return addrs, err
+}
+
+// Dial connects to the TCP address host ("host:port"), through the SOCKS5
+// proxy for host if there is one. Reads from the returned connection are in
+// the response header phase.
+func (d *Dialer) Dial(host string) (*Conn, error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	proxy := socksProxyFor(d.SocksProxies, hostname)
+	if proxy != "" {
+		// The proxy resolves the hostname
+		hostname, port, err = net.SplitHostPort(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy %q in config: %v", proxy, err)
+		}
+	}
+	addrs, err := d.lookup(hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	// The connect timeout is for trying all the addresses, not each of them
+	ctx, cancel := d.context()
+	defer cancel()
+	var dialer net.Dialer
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, port))
+		if err == nil {
+			c := &Conn{Conn: conn}
+			if d.intr != nil {
+				d.intr.track(c)
+			}
+			if proxy != "" {
+				c.SetPhase(PhaseConnect, d.ConnectTimeout, optConnectTimeout)
+				if err := socksConnect(c, host); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			c.SetPhase(PhaseHeader, d.ReadTimeout, optReadTimeout)
+			return c, nil
+		}
+	}
+	var netErr net.Error
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return nil, &TimeoutError{PhaseConnect, d.ConnectTimeout, optConnectTimeout}
+	}
+	return nil, err
+}
+
+// DialUDP resolves host ("host:port") and returns a UDP socket connected to
+// its first address. Timeouts are left to the caller, since datagram
+// protocols retransmit instead.
+func (d *Dialer) DialUDP(host string) (*net.UDPConn, error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	if socksProxyFor(d.SocksProxies, hostname) != "" {
+		// SOCKS5 supports UDP, but Tor does not, so it is not attempted
+		return nil, errors.New("unable to connect over UDP through the SOCKS5 proxy for " + hostname)
+	}
+	addrs, err := d.lookup(hostname)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + hostname)
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err == nil && d.intr != nil {
+		d.intr.track(conn)
+	}
+	return conn, err
+}
+
+// DialTLS connects to host like Dial, then performs a TLS handshake. The
+// underlying *Conn is returned so that the caller can set its phase.
+func (d *Dialer) DialTLS(host string, config *tls.Config) (*tls.Conn, *Conn, error) {
+	raw, err := d.Dial(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.ServerName == "" {
+		// Servers need SNI to know which certificate to present
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(host)
+	}
+	raw.SetPhase(PhaseTLS, d.ConnectTimeout, optConnectTimeout)
+	conn := tls.Client(raw, config)
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, nil, err
+	}
+	raw.SetPhase(PhaseHeader, d.ReadTimeout, optReadTimeout)
+	return conn, raw, nil
+}
+
+// context returns a context that expires after the connect timeout, if any,
+// and is cancelled when d is interrupted
+func (d *Dialer) context() (context.Context, context.CancelFunc) {
+	parent := context.Background()
+	if d.intr != nil {
+		parent = d.intr.ctx
+	}
+	if d.ConnectTimeout > 0 {
+		return context.WithTimeout(parent, d.ConnectTimeout)
+	}
+	return context.WithCancel(parent)
+}
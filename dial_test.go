@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// stallingServer accepts connections and never responds
+func stallingServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDialerTimeouts(t *testing.T) {
+	host := stallingServer(t)
+	d := &Dialer{ConnectTimeout: 50 * time.Millisecond, ReadTimeout: 50 * time.Millisecond}
+	var timeout *TimeoutError
+
+	_, _, err := d.DialTLS(host, &tls.Config{InsecureSkipVerify: true})
+	if !errors.As(err, &timeout) || timeout.Phase != PhaseTLS {
+		t.Errorf("DialTLS() err = %v, want a TLS handshake timeout", err)
+	}
+
+	conn, err := d.Dial(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.As(err, &timeout) || timeout.Phase != PhaseHeader {
+		t.Errorf("Read() err = %v, want a response header timeout", err)
+	}
+	conn.SetPhase(PhaseBody, d.ReadTimeout, optReadTimeout)
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.As(err, &timeout) || timeout.Phase != PhaseBody {
+		t.Errorf("Read() err = %v, want a response body timeout", err)
+	}
+}
+
+func TestDialerInterruptible(t *testing.T) {
+	host := stallingServer(t)
+	d, stop := (&Dialer{ConnectTimeout: time.Second}).Interruptible()
+	conn, err := d.Dial(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Skip("unable to send an interrupt:", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Read() on an interrupted connection succeeded")
+	}
+	if !stop() {
+		t.Error("stop() = false, want true after an interrupt")
+	}
+	if _, err := d.Dial(host); err == nil {
+		t.Error("Dial() with an interrupted dialer succeeded")
+	}
+}
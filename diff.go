@@ -0,0 +1,147 @@
+// Line diffs, to review edits before they are uploaded
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged context shown around changes
+const diffContext = 2
+
+// diffLine is a line of a diff, with op being ' ', '-', or '+'
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Diffs needing a larger table than this many cells are not computed
+// exactly, see lineDiff
+const maxDiffCells = 1 << 20
+
+// lineDiff returns the changes from a to b, line by line. Lines common to the
+// start and end are matched first, since edits are usually in one place.
+func lineDiff(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var diff []diffLine
+	for _, line := range a[:prefix] {
+		diff = append(diff, diffLine{' ', line})
+	}
+	diff = append(diff, lcsDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, diffLine{' ', line})
+	}
+	return diff
+}
+
+// lcsDiff returns the changes from a to b using the longest common
+// subsequence of lines. If the table for it would be larger than
+// maxDiffCells, all of a is shown as removed and all of b as added instead.
+func lcsDiff(a, b []string) []diffLine {
+	var diff []diffLine
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			diff = append(diff, diffLine{'-', line})
+		}
+		for _, line := range b {
+			diff = append(diff, diffLine{'+', line})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, diffLine{'-', a[i]})
+			i++
+		default:
+			diff = append(diff, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, diffLine{'+', b[j]})
+	}
+	return diff
+}
+
+// FormatDiff formats the changes from old to new for display, showing only
+// the changed lines with a few lines of context around them. It returns an
+// empty string if nothing changed.
+func (s *Style) FormatDiff(old, new string) string {
+	diff := lineDiff(strings.Split(old, "\n"), strings.Split(new, "\n"))
+	// Whether each line is close enough to a change to be shown
+	show := make([]bool, len(diff))
+	changed := false
+	for i, line := range diff {
+		if line.op == ' ' {
+			continue
+		}
+		changed = true
+		for k := i - diffContext; k <= i+diffContext; k++ {
+			if k >= 0 && k < len(diff) {
+				show[k] = true
+			}
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var b strings.Builder
+	newLine := 1
+	for i, line := range diff {
+		if show[i] && (i == 0 || !show[i-1]) {
+			b.WriteString(s.StyleSprint(s.diffHunk, fmt.Sprintf("@@ line %d @@", newLine)) + "\n")
+		}
+		if show[i] {
+			text := string(line.op) + " " + line.text
+			switch line.op {
+			case '-':
+				text = s.StyleSprint(s.diffRemoved, text)
+			case '+':
+				text = s.StyleSprint(s.diffAdded, text)
+			}
+			b.WriteString(text + "\n")
+		}
+		if line.op != '-' {
+			newLine++
+		}
+	}
+	return b.String()
+}
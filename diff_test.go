@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	var tests = []struct {
+		old, new string
+		res      string
+	}{
+		{"a\nb\nc", "a\nb\nc", "  a|  b|  c"},
+		{"a\nb\nc", "a\nc", "  a|- b|  c"},
+		{"a\nc", "a\nb\nc", "  a|+ b|  c"},
+		{"a\nb", "a\nB", "  a|- b|+ B"},
+		{"", "x", "- |+ x"},
+	}
+
+	for _, test := range tests {
+		var lines []string
+		for _, line := range lineDiff(strings.Split(test.old, "\n"), strings.Split(test.new, "\n")) {
+			lines = append(lines, string(line.op)+" "+line.text)
+		}
+		if res := strings.Join(lines, "|"); res != test.res {
+			t.Errorf("lineDiff(%q, %q) = %q, want %q", test.old, test.new, res, test.res)
+		}
+	}
+}
+
+func TestFormatDiff(t *testing.T) {
+	s := &Style{}
+	if res := s.FormatDiff("a\nb", "a\nb"); res != "" {
+		t.Errorf("FormatDiff(no changes) = %q, want empty", res)
+	}
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9"
+	res := s.FormatDiff(old, strings.Replace(old, "8", "eight", 1))
+	want := "@@ line 6 @@\n  6\n  7\n- 8\n+ eight\n  9\n"
+	if res != want {
+		t.Errorf("FormatDiff() = %q, want %q", res, want)
+	}
+}
+
+func TestLineDiffLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 3000; i++ {
+		a = append(a, "old "+strconv.Itoa(i))
+		b = append(b, "new "+strconv.Itoa(i))
+	}
+	// Unchanged lines around the edit are kept out of the table
+	a = append([]string{"start"}, append(a, "end")...)
+	b = append([]string{"start"}, append(b, "end")...)
+
+	counts := make(map[byte]int)
+	for _, line := range lineDiff(a, b) {
+		counts[line.op]++
+	}
+	if counts[' '] != 2 || counts['-'] != 3000 || counts['+'] != 3000 {
+		t.Errorf("lineDiff() counts = %v, want 2 unchanged, 3000 removed, and 3000 added", counts)
+	}
+}
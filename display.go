@@ -24,6 +24,11 @@ type Style struct {
 	cmdSynopsis    *color.Color
 	cmdPlaceholder *color.Color
 	cmdLabels      *color.Color // Eg: Usage:
+
+	// Diffs of edited pages
+	diffAdded   *color.Color
+	diffRemoved *color.Color
+	diffHunk    *color.Color
 }
 
 var DefaultStyle = Style{
@@ -42,6 +47,10 @@ var DefaultStyle = Style{
 	cmdSynopsis:    color.New(color.Italic),
 	cmdPlaceholder: color.New(color.FgBlue, color.Italic),
 	cmdLabels:      color.New(color.Bold),
+
+	diffAdded:   color.New(color.FgGreen),
+	diffRemoved: color.New(color.FgRed),
+	diffHunk:    color.New(color.FgCyan),
 }
 
 var (
@@ -67,9 +76,3 @@ func (s *Style) ErrorMsg(msg string) {
 func (s *Style) WarningMsg(msg string) {
 	fmt.Printf("[%s] %s\n", s.StyleSprint(s.Warning, "WARNING"), msg)
 }
-
-// PrintStatus takes the status code and the message and prints a colored message
-func (s *Style) PrintStatus(code int, msg string) {
-	// TODO: have a map or something so we can lookup what code is display
-	// the default msg (like "not found"), and then append servers custom msg
-}
@@ -0,0 +1,167 @@
+// Saving response bodies to files
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Preferred file extensions for media types, for those where
+// mime.ExtensionsByType does not know about, or does not pick the most
+// common extension first
+var preferredExtensions = map[string]string{
+	"text/gemini":   ".gmi",
+	"text/scroll":   ".scroll",
+	"text/plain":    ".txt",
+	"text/html":     ".html",
+	"text/markdown": ".md",
+	"image/jpeg":    ".jpg",
+	"audio/mpeg":    ".mp3",
+	"gophermap":     ".txt",
+	"nex/directory": ".txt",
+}
+
+// downloadFilename picks a file name for a response from the last element of
+// the URL path and its media type
+func downloadFilename(u *url.URL, mediaType string) string {
+	name := path.Base(strings.Split(u.Path, "\t")[0])
+	// The host name has an extension of its own, which is not a file type
+	fromHost := name == "." || name == "/"
+	if fromHost {
+		name = u.Hostname()
+	}
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "download"
+	}
+	if fromHost || path.Ext(name) == "" {
+		if ext, ok := preferredExtensions[mediaType]; ok {
+			name += ext
+		} else if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	return name
+}
+
+// expandPath expands a leading ~ and environment variables in p
+func expandPath(p string) string {
+	p = os.ExpandEnv(p)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// uniquePath returns p, or p with a number added before the extension if p
+// already exists
+func uniquePath(p string) string {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return p
+	}
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := base + "." + strconv.Itoa(i) + ext
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// formatSize formats n bytes for humans
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// progressWriter shows how many bytes have been written so far
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	shown   time.Time
+	show    bool
+}
+
+func (p *progressWriter) Write(b []byte) (n int, err error) {
+	n, err = p.w.Write(b)
+	p.written += int64(n)
+	if p.show && time.Since(p.shown) > 200*time.Millisecond {
+		p.shown = time.Now()
+		fmt.Printf("\rDownloading... %s ", formatSize(p.written))
+	}
+	return
+}
+
+// SavePage writes the body of page to dest, returning the path it was saved
+// to. If dest is empty or a directory, a file name is picked from the URL and
+// media type, without overwriting existing files. A body that has not been
+// read yet is streamed to the file and can be cancelled with Ctrl-C.
+func (c *Client) SavePage(page *Page, dest string) (string, error) {
+	dest = expandPath(dest)
+	if dest == "" {
+		dest = expandPath(c.conf.DownloadDir)
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return "", err
+		}
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = uniquePath(filepath.Join(dest, downloadFilename(page.u, page.mediaType)))
+	} else if err == nil {
+		fmt.Println(dest, "already exists. Overwrite it?")
+		if opt, ok := c.PromptYesNo(); !ok || !opt {
+			return "", errors.New("not overwriting " + dest)
+		}
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	progress := &progressWriter{w: f, show: term.IsTerminal(int(os.Stdout.Fd()))}
+	if page.body == nil {
+		_, err = io.Copy(progress, bytes.NewReader(page.bodyBytes))
+	} else {
+		stop := cancelLoading(page.body, nil)
+		_, err = io.Copy(progress, page.body)
+		if stop() {
+			err = ErrInterrupted
+		}
+		page.body = nil
+	}
+	if progress.show && progress.written > 0 {
+		fmt.Println()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	page.savedPath = dest
+	fmt.Printf("Saved %s to %s\n", formatSize(progress.written), dest)
+	return dest, nil
+}
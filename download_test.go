@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDownloadFilename(t *testing.T) {
+	var tests = []struct {
+		u         string
+		mediaType string
+		res       string
+	}{
+		{"gemini://example.org/files/report.pdf", "application/pdf", "report.pdf"},
+		{"gemini://example.org/files/", "text/gemini", "files.gmi"},
+		{"gemini://example.org/", "text/gemini", "example.org.gmi"},
+		{"gemini://example.org", "text/plain", "example.org.txt"},
+		{"gemini://example.org/photo", "image/jpeg", "photo.jpg"},
+		{"gemini://example.org/.hidden", "text/plain", "hidden.txt"},
+		{"gopher://example.org:70/9/pub/archive.tar.gz", "application/octet-stream", "archive.tar.gz"},
+		{"nex://example.org/dir/", "nex/directory", "dir.txt"},
+		{"spartan://example.org/blob", "application/x-unknown-type", "blob"},
+	}
+
+	for _, test := range tests {
+		res := downloadFilename(mustParse(test.u), test.mediaType)
+		if res != test.res {
+			t.Errorf("downloadFilename(%q, %q) = %q, want %q", test.u, test.mediaType, res, test.res)
+		}
+	}
+}
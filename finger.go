@@ -0,0 +1,59 @@
+// URL Handler for the finger protocol (RFC 1288)
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/url"
+	"strings"
+)
+
+type FingerResponse struct {
+	bodyReader *bufio.Reader
+	conn       net.Conn
+}
+
+// fingerQuery returns the query for u, which is the user from either
+// finger://host/user or finger://user@host. An empty query lists the users
+// of the host.
+func fingerQuery(u *url.URL) string {
+	if user := strings.TrimPrefix(u.Path, "/"); user != "" {
+		return user
+	}
+	return u.User.Username()
+}
+
+// FingerParsedURL fetches u using d and returns a FingerResponse
+func FingerParsedURL(u *url.URL, d *Dialer) (res *FingerResponse, err error) {
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "79" // Default port
+	}
+	// Connect to server, no TLS
+	conn, err := d.Dial(net.JoinHostPort(host, port))
+	if err != nil {
+		return
+	}
+	if _, err = conn.Write([]byte(fingerQuery(u) + "\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+	// There is no response header
+	conn.SetPhase(PhaseBody, d.ReadTimeout, optReadTimeout)
+	res = &FingerResponse{
+		bodyReader: bufio.NewReader(conn),
+		conn:       conn,
+	}
+	return
+}
+
+// fetchFinger fetches u, whose response is always plain text
+func (c *Client) fetchFinger(u *url.URL) (*Response, error) {
+	res, err := FingerParsedURL(u, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{status: StatusSuccess, mediaType: "text/plain", body: readCloser{res.bodyReader, res.conn}}, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFingerQuery(t *testing.T) {
+	var tests = []struct {
+		u   string
+		res string
+	}{
+		{"finger://example.org/alice", "alice"},
+		{"finger://bob@example.org", "bob"},
+		{"finger://example.org", ""},
+		{"finger://example.org/", ""},
+	}
+
+	for _, test := range tests {
+		if res := fingerQuery(mustParse(test.u)); res != test.res {
+			t.Errorf("fingerQuery(%q) = %q, want %q", test.u, res, test.res)
+		}
+	}
+}
+
+func TestFingerParsedURL(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	queries := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query, _ := bufio.NewReader(conn).ReadString('\n')
+		queries <- query
+		conn.Write([]byte("Plan:\r\nWriting a finger client\r\n"))
+	}()
+
+	d := &Dialer{ConnectTimeout: time.Second, ReadTimeout: time.Second}
+	res, err := FingerParsedURL(mustParse("finger://alice@"+l.Addr().String()), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.conn.Close()
+	body, err := ioutil.ReadAll(res.bodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query := <-queries; query != "alice\r\n" {
+		t.Errorf("query = %q, want %q", query, "alice\r\n")
+	}
+	if string(body) != "Plan:\r\nWriting a finger client\r\n" {
+		t.Errorf("body = %q", body)
+	}
+}
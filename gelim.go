@@ -5,7 +5,6 @@ import (
 	"io"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -36,23 +35,10 @@ var (
 // Pager uses `less` to display body
 // falls back to fmt.Print if errors encountered
 func Pager(body string, conf *Config) {
-	cmd := exec.Command("less")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		fmt.Print(body)
-		return
-	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), "LESS="+conf.LessOpts)
-	if err := cmd.Start(); err != nil {
-		fmt.Print(body)
-		return
-	}
-	io.WriteString(stdin, body)
-	stdin.Close()
-	cmd.Stdin = os.Stdin
-	cmd.Wait()
+	w, wait, _ := PagerWriter(conf, 0)
+	io.WriteString(w, body)
+	w.Close()
+	wait()
 }
 
 func queryEscape(s string) string {
@@ -113,7 +99,7 @@ func main() {
 			if *appendInput != "" {
 				u = u + "?" + queryEscape(*appendInput)
 			}
-			c.HandleURLWrapper(u)
+			c.HandleURL(u)
 			cliURL = true
 		} else {
 			// if --input used but url arg is not present
@@ -125,12 +111,12 @@ func main() {
 		}
 	}
 	if *noInteractive {
-		return
+		c.QuitClient(0)
 	}
 
 	if !cliURL {
 		if c.conf.StartURL != "" {
-			c.HandleURLWrapper(c.conf.StartURL)
+			c.HandleURL(c.conf.StartURL)
 		} else {
 			fmt.Println("Welcome! Use the 'help' command to get started.")
 		}
@@ -210,7 +196,9 @@ func main() {
 			// ----
 			//   "foo.txt" -> "gemini://foo.txt"
 			//   "./foo.txt" -> "gemini://current-url.org/foo.txt"
-			if (parsed.Scheme == "" || parsed.Host == "") &&
+			// URLs such as mailto: have no host, but their scheme has a
+			// command configured
+			if (parsed.Scheme == "" || parsed.Host == "" && c.schemeCommand(parsed.Scheme) == "") &&
 				(!strings.HasPrefix(u, ".")) && (!strings.HasPrefix(u, "/")) {
 				parsed, err = url.Parse("gemini://" + u)
 				if err != nil {
@@ -222,8 +210,8 @@ func main() {
 				}
 			}
 			// this allows users to use relative urls at the prompt
-			if len(c.history) != 0 {
-				parsed = c.history[len(c.history)-1].ResolveReference(parsed)
+			if c.currentURL() != nil {
+				parsed = c.currentURL().ResolveReference(parsed)
 			} else {
 				if strings.HasPrefix(u, ".") || strings.HasPrefix(u, "/") {
 					c.style.ErrorMsg("No history yet, cannot use relative URLs")
@@ -241,7 +229,7 @@ func main() {
 			continue
 		}
 		// link index lookup
-		if len(c.history) == 0 {
+		if c.currentURL() == nil && c.currentPage == nil {
 			c.style.ErrorMsg("No history yet, cannot use link indexing")
 			continue
 		}
@@ -250,10 +238,15 @@ func main() {
 			c.style.ErrorMsg("Empty URL for this input link!")
 			continue
 		}
+		c.rememberLink(index)
+		if isInput && strings.HasPrefix(u, "spartan://") {
+			c.SpartanInput(u)
+			continue
+		}
 		if isInput {
 			c.Input(u, false)
 			continue
 		}
-		c.HandleURLWrapper(u)
+		c.HandleURL(u)
 	}
 }
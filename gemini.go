@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"mime"
 	"strconv"
 
@@ -28,8 +30,23 @@ type GeminiResponse struct {
 //ErrDecodeMetaFail = errors.New("failed to decode meta header")
 //)
 
-// GeminiParsedURL fetches u and returns *GeminiResponse
-func GeminiParsedURL(u url.URL, cert tls.Certificate) (res *GeminiResponse, err error) {
+// GeminiParsedURL fetches u using d and returns *GeminiResponse. The server
+// certificate is checked against hosts before the request is sent.
+//
+// If proxy is not empty, the request is sent to the gemini server at that
+// host:port instead of the host of u, which can be a URL of another scheme.
+func GeminiParsedURL(u url.URL, proxy string, cert tls.Certificate, hosts *KnownHosts, d *Dialer) (res *GeminiResponse, err error) {
+	server := u
+	if proxy != "" {
+		server = url.URL{Host: proxy}
+	}
+	return geminiRequest(server, u.String()+"\r\n", nil, cert, hosts, d)
+}
+
+// geminiRequest sends request to the host of u followed by body, if any, and
+// reads the response header. This is shared with Titan, whose responses are
+// the same as Gemini.
+func geminiRequest(u url.URL, request string, body io.Reader, cert tls.Certificate, hosts *KnownHosts, d *Dialer) (res *GeminiResponse, err error) {
 	host := u.Host
 	// Connect to server
 	if u.Port() == "" {
@@ -42,13 +59,28 @@ func GeminiParsedURL(u url.URL, cert tls.Certificate) (res *GeminiResponse, err
 	if cert.Certificate != nil {
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-	conn, err := tls.Dial("tcp", host, tlsConfig)
+	conn, raw, err := d.DialTLS(host, tlsConfig)
 	if err != nil {
 		return
 	}
+	// Certificates are self-signed more often than not, so trust on first use
+	// instead of verifying the chain
+	if err = hosts.Check(host, conn.ConnectionState().PeerCertificates[0]); err != nil {
+		conn.Close()
+		return
+	}
 	// defer conn.Close()
 	// Send request
-	conn.Write([]byte(u.String() + "\r\n"))
+	if _, err = conn.Write([]byte(request)); err != nil {
+		conn.Close()
+		return
+	}
+	if body != nil {
+		if _, err = io.Copy(conn, body); err != nil {
+			conn.Close()
+			return
+		}
+	}
 	// Receive and parse response header
 	reader := bufio.NewReader(conn)
 	responseHeader, err := reader.ReadString('\n')
@@ -56,6 +88,7 @@ func GeminiParsedURL(u url.URL, cert tls.Certificate) (res *GeminiResponse, err
 		conn.Close()
 		return
 	}
+	raw.SetPhase(PhaseBody, d.ReadTimeout, optReadTimeout)
 	// Parse header
 	parts := strings.Fields(responseHeader)
 	if len(parts) == 0 {
@@ -73,6 +106,68 @@ func GeminiParsedURL(u url.URL, cert tls.Certificate) (res *GeminiResponse, err
 	return
 }
 
+// fetchGemini fetches u with the client certificate for it
+func (c *Client) fetchGemini(u *url.URL) (*Response, error) {
+	res, err := GeminiParsedURL(*u, "", c.getClientCert(u), c.knownHosts, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	return geminiResponse(res)
+}
+
+// geminiProxy is a Protocol that fetches URLs from the gemini server at host,
+// as set by the proxy of a scheme in the schemes config. The client
+// certificate for a URL is only sent to the proxy if sendCert is set.
+type geminiProxy struct {
+	host     string
+	sendCert bool
+}
+
+func (p geminiProxy) Fetch(c *Client, u *url.URL) (*Response, error) {
+	var cert tls.Certificate
+	if p.sendCert {
+		cert = c.getClientCert(u)
+	}
+	res, err := GeminiParsedURL(*u, p.host, cert, c.knownHosts, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	return geminiResponse(res)
+}
+
+// geminiResponse converts res, a response in the format of gemini, to a
+// Response. Statuses are converted by their first digit.
+func geminiResponse(res *GeminiResponse) (*Response, error) {
+	r := &Response{code: res.status, meta: res.meta, undefined: !geminiStatusDefined(res.status)}
+	switch res.status / 10 {
+	case 1:
+		r.status = StatusInput
+		if res.status == 11 {
+			r.status = StatusSensitiveInput
+		}
+	case 2:
+		mediaType, params, err := ParseMeta(res.meta)
+		if err != nil {
+			res.conn.Close()
+			return nil, fmt.Errorf("Unable to parse header meta\"%s\": %s", res.meta, err)
+		}
+		r.status, r.mediaType, r.params = StatusSuccess, mediaType, params
+		r.body = readCloser{res.bodyReader, res.conn}
+		return r, nil
+	case 3:
+		r.status = StatusRedirect
+	case 4, 5:
+		r.status = StatusFailure
+	case 6:
+		r.status = StatusCertRequired
+	default:
+		res.conn.Close()
+		return nil, fmt.Errorf("Invalid status code %d", res.status)
+	}
+	res.conn.Close()
+	return r, nil
+}
+
 // ParseMeta returns the output of mime.ParseMediaType, but handles the empty
 // META which is equal to "text/gemini; charset=utf-8" according to the spec.
 func ParseMeta(meta string) (string, map[string]string, error) {
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
 	"errors"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type ParseMetaResult struct {
@@ -47,3 +52,43 @@ func TestParseMeta(t *testing.T) {
 		}
 	}
 }
+
+func TestGeminiParsedURLProxy(t *testing.T) {
+	dir := t.TempDir()
+	ids, err := LoadIdentities(dir, filepath.Join(dir, "scopes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ids.Generate("server", "localhost", "ecdsa", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{ids.certs["server"]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("20 text/plain\r\n" + request))
+	}()
+
+	hosts, err := LoadKnownHosts(filepath.Join(dir, "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Dialer{ConnectTimeout: time.Second, ReadTimeout: time.Second}
+	res, err := GeminiParsedURL(*mustParse("gopher://example.org/1/"), ln.Addr().String(), tls.Certificate{}, hosts, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.conn.Close()
+	body, _ := ioutil.ReadAll(res.bodyReader)
+	if res.status != 20 || string(body) != "gopher://example.org/1/\r\n" {
+		t.Errorf("proxied response = %d %q, want 20 with the gopher URL as the request", res.status, body)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"mime"
 	"net"
 	"net/url"
+	"path"
 	"strings"
 )
 
@@ -25,6 +27,7 @@ var gophertypes = map[string]string{
 	"7": "SEARCH",
 	"8": "TEL",
 	"9": "BIN",
+	"d": "DOC",
 	"g": "GIF",
 	"G": "GMI",
 	"h": "HTML",
@@ -33,19 +36,64 @@ var gophertypes = map[string]string{
 	"s": "SND",
 	"S": "SSH",
 	"T": "TEL",
+	";": "VID",
 }
 
-// GopherParsedURL fetches u and returns a GopherResponse
-func GopherParsedURL(u *url.URL) (res *GopherResponse, err error) {
+// Media types of gopher item types. Those ending in /* only name the family,
+// the exact type is sniffed from the body.
+var gopherMediaTypes = map[string]string{
+	"0": "text/plain",
+	"1": "gophermap",
+	"4": "application/mac-binhex40",
+	"5": "application/octet-stream",
+	"6": "text/x-uuencode",
+	"7": "gophermap",
+	"9": "application/octet-stream",
+	"d": "application/pdf",
+	"g": "image/gif",
+	"h": "text/html",
+	"I": "image/*",
+	"p": "image/png",
+	"s": "audio/*",
+	";": "video/*",
+}
+
+// gopherMediaType returns the media type for a page of the given gopher item
+// type, sniffing the body where the item type is not specific enough
+func gopherMediaType(gophertype string, page *Page) string {
+	mediaType, ok := gopherMediaTypes[gophertype]
+	if !ok {
+		// Unknown item types are often text, but sniff to avoid printing
+		// binary files
+		return page.sniff()
+	}
+	if !strings.HasSuffix(mediaType, "/*") {
+		return mediaType
+	}
+	family := strings.TrimSuffix(mediaType, "*")
+	if sniffed := page.sniff(); strings.HasPrefix(sniffed, family) {
+		return sniffed
+	}
+	if byExt, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(page.u.Path))); strings.HasPrefix(byExt, family) {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+// GopherParsedURL fetches u using d and returns a GopherResponse
+func GopherParsedURL(u *url.URL, d *Dialer) (res *GopherResponse, err error) {
 	host := u.Host
 	if u.Port() == "" {
 		host += ":70"
 	}
 	// Connect to server, no TLS
-	conn, err := net.Dial("tcp", host)
+	dconn, err := d.Dial(host)
 	if err != nil {
 		return
 	}
+	// There is no response header
+	dconn.SetPhase(PhaseBody, d.ReadTimeout, optReadTimeout)
+	var conn net.Conn = dconn
 	fullpath := strings.TrimPrefix(u.Path, "/")
 	if fullpath == "" || fullpath == "1" {
 		fullpath = "1/"
@@ -64,18 +112,15 @@ func GopherParsedURL(u *url.URL) (res *GopherResponse, err error) {
 	return
 }
 
-func (c *Client) ParseGophermap(page *Page) string {
+// gophermapRenderer returns a lineRenderer for gophermaps, which adds links
+// to c.links as they are rendered.
+func (c *Client) gophermapRenderer(page *Page) lineRenderer {
 	var linkStyle = c.style.gmiLink.Sprint
-	body := string(page.bodyBytes)
-	rendered := []string{}
-	dedents := []int{}
 
-	for _, line := range strings.Split(body, "\n") {
+	return func(line string) (renderedLine, bool) {
 		line = strings.Trim(line, "\r\n")
 		if line == "." {
-			rendered = append(rendered, "")
-			dedents = append(dedents, 0)
-			continue
+			return renderedLine{}, true
 		}
 
 		columns := strings.Split(line, "\t")
@@ -90,47 +135,48 @@ func (c *Client) ParseGophermap(page *Page) string {
 		}
 
 		if len(columns) < 4 || strings.HasPrefix(columns[0], "i") {
-			dedents = append(dedents, 0)
-			rendered = append(rendered, title)
-		} else {
-			host := columns[2]
-			port := columns[3]
-			gtype := string(columns[0][0])
-			path := columns[1]
+			return renderedLine{text: title, width: len(title)}, true
+		}
+		host := columns[2]
+		port := columns[3]
+		gtype := string(columns[0][0])
+		path := columns[1]
 
-			link := fmt.Sprintf("gopher://%s:%s/%s%s", host, port, gtype, path)
-			switch gtype {
-			case "8", "T":
-				link = fmt.Sprintf("telnet://%s:%s", host, port)
-			case "G":
-				link = fmt.Sprintf("gemini://%s:%s%s", host, port, path)
-			case "h":
-				u, tf := isWebLink(path)
-				if tf {
-					if strings.Index(u, "://") > 0 {
-						link = u
-					} else {
-						link = fmt.Sprintf("http://%s", u)
-					}
+		link := fmt.Sprintf("gopher://%s:%s/%s%s", host, port, gtype, path)
+		switch gtype {
+		case "8", "T":
+			link = fmt.Sprintf("telnet://%s:%s", host, port)
+		case "G":
+			link = fmt.Sprintf("gemini://%s:%s%s", host, port, path)
+		case "h":
+			u, tf := isWebLink(path)
+			if tf {
+				if strings.Index(u, "://") > 0 {
+					link = u
 				} else {
-					link = fmt.Sprintf("gopher://%s:%s/h%s", host, port, path)
+					link = fmt.Sprintf("http://%s", u)
 				}
-			case "7":
-				c.inputLinks = append(c.inputLinks, len(c.links))
+			} else {
+				link = fmt.Sprintf("gopher://%s:%s/h%s", host, port, path)
+			}
+		case "7":
+			c.inputLinks = append(c.inputLinks, len(c.links))
+		case "0":
+			// Finger plans are linked as text files on the finger port
+			if port == "79" {
+				link = fmt.Sprintf("finger://%s/%s", host, strings.TrimPrefix(path, "/"))
 			}
-			c.links = append(c.links, link)
-			gophertype := "(" + getGophertype(string(columns[0][0])) + ")"
-			linkLine := fmt.Sprintf("%s  [%d] %s", gophertype, len(c.links), linkStyle(title))
-			dedents = append(dedents, len(gophertype)+2)
-			rendered = append(rendered, linkLine)
 		}
+		c.links = append(c.links, link)
+		gophertype := "(" + getGophertype(string(columns[0][0])) + ")"
+		linkLine := fmt.Sprintf("%s  [%d] %s", gophertype, len(c.links), linkStyle(title))
+		return renderedLine{text: linkLine, width: len(linkLine), dedent: len(gophertype) + 2}, true
 	}
-
-	return c.Centered(rendered, 0, dedents)
 }
 
 func isWebLink(resource string) (string, bool) {
-	split := strings.SplitN(resource, ":", 2)
+	// Some servers put a slash before URL:
+	split := strings.SplitN(strings.TrimPrefix(resource, "/"), ":", 2)
 	if first := strings.ToUpper(split[0]); first == "URL" && len(split) > 1 {
 		return split[1], true
 	}
@@ -143,3 +189,15 @@ func getGophertype(t string) string {
 	}
 	return "???"
 }
+
+// fetchGopher fetches u, guessing the media type from the item type in its
+// path
+func (c *Client) fetchGopher(u *url.URL) (*Response, error) {
+	res, err := GopherParsedURL(u, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{u: u, body: readCloser{res.bodyReader, *res.conn}}
+	mediaType := gopherMediaType(res.gophertype, page)
+	return &Response{status: StatusSuccess, mediaType: mediaType, body: page.body}, nil
+}
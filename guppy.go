@@ -0,0 +1,235 @@
+// URL Handler for the guppy protocol
+//
+// Guppy runs over UDP. The response is split into packets with increasing
+// sequence numbers, each of which is acknowledged by the client, and the
+// server sends the packets again until they are acknowledged.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses of guppy responses. Success responses have no status, only a
+// sequence number, so guppySuccess is not on the wire.
+const (
+	guppyInput    = 1
+	guppySuccess  = 2
+	guppyRedirect = 3
+	guppyError    = 4
+)
+
+// Sequence numbers below this are statuses
+const guppyMinSeq = 6
+
+// How long to wait for the first response before sending the request again.
+// The wait doubles each time, up to guppyMaxRetransmit.
+var guppyRetransmit = 500 * time.Millisecond
+
+const guppyMaxRetransmit = 8 * time.Second
+
+// Times the request is sent before giving up on a server that does not
+// respond, even if the read timeout is disabled
+const guppyMaxRequests = 8
+
+type GuppyResponse struct {
+	status int
+	meta   string // Prompt, redirect URL, error message, or media type
+	body   []byte
+}
+
+// guppyPacket is a parsed response packet
+type guppyPacket struct {
+	seq    int    // 0 for status packets
+	status int    // 0 for packets with a sequence number
+	meta   string // After the first space of the header
+	first  bool   // Whether this is the first packet, which has a media type
+	data   []byte
+	eof    bool // Whether this packet marks the end of the response
+}
+
+func parseGuppyPacket(b []byte) (p guppyPacket, err error) {
+	end := bytes.Index(b, []byte("\r\n"))
+	if end == -1 {
+		return p, errors.New("invalid guppy packet, no header")
+	}
+	header := string(b[:end])
+	p.data = b[end+2:]
+	parts := strings.SplitN(header, " ", 2)
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 1 {
+		return p, fmt.Errorf("invalid guppy packet header %q", header)
+	}
+	if len(parts) == 2 {
+		p.meta = parts[1]
+	}
+	if n < guppyMinSeq {
+		p.status = n
+		return p, nil
+	}
+	p.seq = n
+	p.first = len(parts) == 2
+	// Packets after the first have no media type, so one with no data
+	// marks the end
+	p.eof = !p.first && len(p.data) == 0
+	return p, nil
+}
+
+// guppyAssembler puts packets that may arrive out of order or more than once
+// back together
+type guppyAssembler struct {
+	first, eof int // Sequence numbers of the first and end packets, 0 until known
+	meta       string
+	chunks     map[int][]byte
+	size       int
+}
+
+// add stores p and returns whether the whole response has been received
+func (a *guppyAssembler) add(p guppyPacket) bool {
+	if a.chunks == nil {
+		a.chunks = make(map[int][]byte)
+	}
+	if p.eof {
+		a.eof = p.seq
+	} else if _, ok := a.chunks[p.seq]; !ok {
+		if p.first {
+			a.first, a.meta = p.seq, p.meta
+		}
+		a.chunks[p.seq] = p.data
+		a.size += len(p.data)
+	}
+	if a.eof == 0 || a.first == 0 {
+		return false
+	}
+	for seq := a.first; seq < a.eof; seq++ {
+		if _, ok := a.chunks[seq]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *guppyAssembler) body() []byte {
+	var buf bytes.Buffer
+	for seq := a.first; seq < a.eof; seq++ {
+		buf.Write(a.chunks[seq])
+	}
+	return buf.Bytes()
+}
+
+// GuppyParsedURL fetches u using d and returns the reassembled response. The
+// request is sent again with backoff until the server responds, at most
+// guppyMaxRequests times, and the response is abandoned if no packet arrives
+// within the read timeout, or if it grows beyond maxSize bytes (if positive).
+func GuppyParsedURL(u *url.URL, d *Dialer, maxSize int64) (res *GuppyResponse, err error) {
+	host := u.Host
+	if u.Port() == "" {
+		host += ":6775" // Default port
+	}
+	conn, err := d.DialUDP(host)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	request := []byte(u.String() + "\r\n")
+	if _, err = conn.Write(request); err != nil {
+		return
+	}
+	requests := 1
+	var a guppyAssembler
+	received := false
+	start := time.Now()
+	retransmit := guppyRetransmit
+	buf := make([]byte, 64*1024)
+	for {
+		switch {
+		case !received:
+			conn.SetReadDeadline(time.Now().Add(retransmit))
+		case d.ReadTimeout > 0:
+			// The server is responsible for sending lost packets again
+			conn.SetReadDeadline(time.Now().Add(d.ReadTimeout))
+		default:
+			conn.SetReadDeadline(time.Time{})
+		}
+		var n int
+		n, err = conn.Read(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			if received {
+				return nil, &TimeoutError{PhaseBody, d.ReadTimeout, optReadTimeout}
+			}
+			if d.ReadTimeout > 0 && time.Since(start) >= d.ReadTimeout {
+				return nil, &TimeoutError{PhaseHeader, d.ReadTimeout, optReadTimeout}
+			}
+			if requests == guppyMaxRequests {
+				return nil, fmt.Errorf("no response after sending the request %d times", requests)
+			}
+			if retransmit *= 2; retransmit > guppyMaxRetransmit {
+				retransmit = guppyMaxRetransmit
+			}
+			if _, err = conn.Write(request); err != nil {
+				return
+			}
+			requests++
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		p, perr := parseGuppyPacket(buf[:n])
+		if perr != nil {
+			// Could be a stray packet, the server sends the real one again
+			continue
+		}
+		received = true
+		if p.status != 0 {
+			return &GuppyResponse{status: p.status, meta: p.meta}, nil
+		}
+		// Data is copied since buf is reused
+		p.data = append([]byte(nil), p.data...)
+		if _, err = conn.Write([]byte(strconv.Itoa(p.seq) + "\r\n")); err != nil {
+			return
+		}
+		if a.add(p) {
+			return &GuppyResponse{status: guppySuccess, meta: a.meta, body: a.body()}, nil
+		}
+		if maxSize > 0 && int64(a.size) > maxSize {
+			return nil, ErrBodyTooLarge
+		}
+	}
+}
+
+// fetchGuppy fetches u, limiting the response to maxBodySize
+func (c *Client) fetchGuppy(u *url.URL) (*Response, error) {
+	res, err := GuppyParsedURL(u, c.dialer, int64(c.conf.MaxBodySize)<<20)
+	if err != nil {
+		return nil, err
+	}
+	r := &Response{code: res.status, meta: res.meta}
+	switch res.status {
+	case guppyInput:
+		r.status = StatusInput
+	case guppySuccess:
+		mediaType, params, err := ParseMeta(res.meta)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse media type \"%s\": %s", res.meta, err)
+		}
+		r.status, r.mediaType, r.params = StatusSuccess, mediaType, params
+		r.body = ioutil.NopCloser(bytes.NewReader(res.body))
+	case guppyRedirect:
+		r.status = StatusRedirect
+	case guppyError:
+		r.status = StatusFailure
+	}
+	return r, nil
+}
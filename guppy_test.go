@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGuppyPacket(t *testing.T) {
+	var tests = []struct {
+		packet string
+		res    guppyPacket
+		ok     bool
+	}{
+		{"1 Your name?\r\n", guppyPacket{status: 1, meta: "Your name?"}, true},
+		{"3 /other\r\n", guppyPacket{status: 3, meta: "/other"}, true},
+		{"1234 text/gemini\r\n# Hi", guppyPacket{seq: 1234, meta: "text/gemini", first: true, data: []byte("# Hi")}, true},
+		{"1235\r\nmore", guppyPacket{seq: 1235, data: []byte("more")}, true},
+		{"1236\r\n", guppyPacket{seq: 1236, data: []byte{}, eof: true}, true},
+		{"no header", guppyPacket{}, false},
+		{"x\r\n", guppyPacket{}, false},
+	}
+
+	for _, test := range tests {
+		res, err := parseGuppyPacket([]byte(test.packet))
+		if (err == nil) != test.ok {
+			t.Errorf("parseGuppyPacket(%q) err = %v, want ok %v", test.packet, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if res.seq != test.res.seq || res.status != test.res.status || res.meta != test.res.meta ||
+			res.first != test.res.first || res.eof != test.res.eof || string(res.data) != string(test.res.data) {
+			t.Errorf("parseGuppyPacket(%q) = %+v, want %+v", test.packet, res, test.res)
+		}
+	}
+}
+
+// guppyServer ignores the first request, then responds with packets out of
+// order and sends the first packet again until it is acknowledged
+func guppyServer(t *testing.T) (addr string, acks chan string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	acks = make(chan string, 10)
+	go func() {
+		buf := make([]byte, 1024)
+		requests := 0
+		acked := false
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg := string(buf[:n])
+			if strings.HasPrefix(msg, "guppy://") {
+				requests++
+				if requests == 1 {
+					continue
+				}
+				for _, p := range []string{"11\r\nworld\n", "12\r\n", "10 text/gemini\r\nhello "} {
+					conn.WriteToUDP([]byte(p), client)
+				}
+				continue
+			}
+			acks <- strings.TrimSpace(msg)
+			if msg == "10\r\n" && !acked {
+				acked = true
+				// A late copy of an acknowledged packet
+				conn.WriteToUDP([]byte("10 text/gemini\r\nhello "), client)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), acks
+}
+
+func TestGuppyParsedURL(t *testing.T) {
+	guppyRetransmit = 20 * time.Millisecond
+	defer func() { guppyRetransmit = 500 * time.Millisecond }()
+
+	addr, acks := guppyServer(t)
+	d := &Dialer{ConnectTimeout: time.Second, ReadTimeout: time.Second}
+	res, err := GuppyParsedURL(mustParse("guppy://"+addr+"/"), d, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.status != guppySuccess || res.meta != "text/gemini" {
+		t.Errorf("response = %d %q, want success with text/gemini", res.status, res.meta)
+	}
+	if string(res.body) != "hello world\n" {
+		t.Errorf("body = %q, want %q", res.body, "hello world\n")
+	}
+	seen := make(map[string]bool)
+	for len(seen) < 3 {
+		select {
+		case ack := <-acks:
+			seen[ack] = true
+		case <-time.After(time.Second):
+			t.Fatalf("acknowledged %v, want 10, 11, and 12", seen)
+		}
+	}
+}
+
+func TestGuppyParsedURLNoResponse(t *testing.T) {
+	guppyRetransmit = time.Millisecond
+	defer func() { guppyRetransmit = 500 * time.Millisecond }()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	requests := make(chan struct{}, 2*guppyMaxRequests)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, _, err := conn.ReadFromUDP(buf); err != nil {
+				return
+			}
+			requests <- struct{}{}
+		}
+	}()
+
+	// Without a read timeout, the request is still only sent a few times
+	d := &Dialer{ConnectTimeout: time.Second}
+	if _, err := GuppyParsedURL(mustParse("guppy://"+conn.LocalAddr().String()+"/"), d, 0); err == nil {
+		t.Fatal("GuppyParsedURL() of a server that never responds succeeded")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := len(requests); n != guppyMaxRequests {
+		t.Errorf("request sent %d times, want %d", n, guppyMaxRequests)
+	}
+}
@@ -0,0 +1,122 @@
+// Opening content in external programs by media type
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// handlerFor returns the command configured in the handlers table for
+// mediaType. An exact match is preferred, then the longest matching pattern.
+func handlerFor(handlers map[string]string, mediaType string) (command string, ok bool) {
+	if command, ok = handlers[mediaType]; ok {
+		return
+	}
+	matched := ""
+	for pattern, cmd := range handlers {
+		if m, _ := path.Match(pattern, mediaType); m && len(pattern) > len(matched) {
+			matched, command, ok = pattern, cmd, true
+		}
+	}
+	return
+}
+
+// OpenWithHandler opens the body of page with an external command. If any
+// argument of the command contains %s, the body is saved to a temporary file
+// and %s is replaced with its path. Otherwise the body is streamed to the
+// command's stdin.
+func (c *Client) OpenWithHandler(page *Page, command string) error {
+	parts, err := shlex.Split(command)
+	if err != nil {
+		return errors.New("could not parse handler into command and arguments: " + command)
+	}
+	if len(parts) == 0 {
+		return errors.New("empty handler for " + page.mediaType)
+	}
+
+	body := page.body
+	if body == nil {
+		body = ioutil.NopCloser(strings.NewReader(string(page.bodyBytes)))
+	}
+	defer func() { page.body = nil }()
+
+	usesFile := false
+	for _, arg := range parts[1:] {
+		if strings.Contains(arg, "%s") {
+			usesFile = true
+			break
+		}
+	}
+	if usesFile {
+		// The file is only removed on exit, because the command could be a
+		// launcher that returns before the program opening the file is done
+		dir, err := c.tempDir()
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, downloadFilename(page.u, page.mediaType))
+		f, err := os.Create(uniquePath(name))
+		if err != nil {
+			return err
+		}
+		stop := cancelLoading(body, nil)
+		_, err = io.Copy(f, body)
+		if stop() {
+			err = ErrInterrupted
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for i := 1; i < len(parts); i++ {
+			parts[i] = strings.ReplaceAll(parts[i], "%s", f.Name())
+		}
+	}
+
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if usesFile {
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	stop := cancelLoading(body, nil)
+	io.Copy(stdin, body)
+	stdin.Close()
+	interrupted := stop()
+	err = cmd.Wait()
+	if interrupted {
+		return ErrInterrupted
+	}
+	return err
+}
+
+// tempDir returns the directory for temporary files of this session, which
+// is removed by QuitClient
+func (c *Client) tempDir() (string, error) {
+	if c.tempPath != "" {
+		return c.tempPath, nil
+	}
+	dir, err := ioutil.TempDir("", "gelim")
+	if err != nil {
+		return "", err
+	}
+	c.tempPath = dir
+	return dir, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHandlerFor(t *testing.T) {
+	handlers := map[string]string{
+		"image/*":         "feh -",
+		"image/gif":       "gifview %s",
+		"*/*":             "xdg-open %s",
+		"application/pdf": "zathura %s",
+	}
+	var tests = []struct {
+		mediaType string
+		command   string
+		ok        bool
+	}{
+		{"image/png", "feh -", true},
+		{"image/gif", "gifview %s", true},
+		{"application/pdf", "zathura %s", true},
+		{"audio/ogg", "xdg-open %s", true},
+		{"gophermap", "", false},
+	}
+
+	for _, test := range tests {
+		command, ok := handlerFor(handlers, test.mediaType)
+		if command != test.command || ok != test.ok {
+			t.Errorf("handlerFor(%q) = %q, %v, want %q, %v", test.mediaType, command, ok, test.command, test.ok)
+		}
+	}
+	if _, ok := handlerFor(nil, "image/png"); ok {
+		t.Error("handlerFor with no handlers should not match")
+	}
+}
@@ -0,0 +1,215 @@
+// History of visited URLs kept across sessions
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is a visit to a URL
+type HistoryEntry struct {
+	Time  time.Time
+	URL   string
+	Title string
+}
+
+// History is the log of visited URLs, saved in a file with one tab-separated
+// "time URL title" entry per line, oldest first.
+type History struct {
+	path    string
+	entries []HistoryEntry
+	// Maximum number of entries kept in the file, older ones are pruned. No
+	// history is saved if it is 0.
+	size int
+}
+
+// LoadHistory reads the history file at path. A missing file is not an error,
+// and lines that could not be parsed are skipped.
+func LoadHistory(path string, size int) (*History, error) {
+	h := &History{path: path, size: size}
+	entries, err := readHistoryFile(path)
+	h.entries = entries
+	return h, err
+}
+
+func readHistoryFile(path string) (entries []HistoryEntry, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if entry, ok := parseHistoryEntry(scanner.Text()); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func parseHistoryEntry(line string) (entry HistoryEntry, ok bool) {
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) < 2 || fields[1] == "" {
+		return
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return
+	}
+	entry = HistoryEntry{Time: t, URL: fields[1]}
+	if len(fields) == 3 {
+		entry.Title = fields[2]
+	}
+	return entry, true
+}
+
+func (e HistoryEntry) String() string {
+	// Titles come from pages, which should not be able to add lines
+	title := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, e.Title)
+	return strings.TrimRight(e.Time.Format(time.RFC3339)+"\t"+e.URL+"\t"+title, "\t")
+}
+
+// Add records a visit to u, appending it to the history file right away so
+// that visits are kept even if gelim does not exit cleanly.
+func (h *History) Add(u string, title string, now time.Time) error {
+	if h.size == 0 {
+		return nil
+	}
+	entry := HistoryEntry{Time: now.UTC().Truncate(time.Second), URL: u, Title: title}
+	h.entries = append(h.entries, entry)
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(f, entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Prune removes the oldest entries from the history file so that at most
+// h.size entries are kept. The file is read again because other sessions may
+// have added entries to it.
+func (h *History) Prune() error {
+	entries, err := readHistoryFile(h.path)
+	if err != nil || len(entries) <= h.size {
+		return err
+	}
+	entries = entries[len(entries)-h.size:]
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.String() + "\n"
+	}
+	h.entries = entries
+	return ioutil.WriteFile(h.path, []byte(strings.Join(lines, "")), 0600)
+}
+
+// Search returns the entries whose URL or title contains term, ignoring case,
+// most recent first. Only the latest visit of each URL is returned.
+func (h *History) Search(term string) (results []HistoryEntry) {
+	term = strings.ToLower(term)
+	seen := make(map[string]bool)
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		entry := h.entries[i]
+		if seen[entry.URL] {
+			continue
+		}
+		if strings.Contains(strings.ToLower(entry.URL), term) || strings.Contains(strings.ToLower(entry.Title), term) {
+			seen[entry.URL] = true
+			results = append(results, entry)
+		}
+	}
+	return
+}
+
+// Similar returns at most limit entries of the history on the same host as
+// u, which share the start of their path or the last part of it with the path
+// of u. Entries sharing more of the path come first, then the most recent.
+func (h *History) Similar(u *url.URL, limit int) []HistoryEntry {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	type scored struct {
+		entry HistoryEntry
+		score int
+	}
+	var results []scored
+	for _, entry := range h.Search("") {
+		other, err := url.Parse(entry.URL)
+		if err != nil || other.Host != u.Host || other.Scheme != u.Scheme || entry.URL == u.String() {
+			continue
+		}
+		otherSegments := strings.Split(strings.Trim(other.Path, "/"), "/")
+		score := 0
+		for score < len(segments) && score < len(otherSegments) && segments[score] == otherSegments[score] && segments[score] != "" {
+			score++
+		}
+		if last := segments[len(segments)-1]; last != "" && last == otherSegments[len(otherSegments)-1] {
+			score++
+		}
+		if score > 0 {
+			results = append(results, scored{entry, score})
+		}
+	}
+	// Search returns the most recent first, which the stable sort keeps for
+	// equal scores
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+	var entries []HistoryEntry
+	for i := 0; i < len(results) && i < limit; i++ {
+		entries = append(entries, results[i].entry)
+	}
+	return entries
+}
+
+// historyGemtext formats history entries as a gemtext page of links
+func historyGemtext(heading string, entries []HistoryEntry) []byte {
+	var b strings.Builder
+	b.WriteString("# " + heading + "\n\n")
+	for _, entry := range entries {
+		label := entry.Time.Local().Format("2006-01-02 15:04")
+		if entry.Title != "" {
+			label += " " + entry.Title
+		} else {
+			label += " " + entry.URL
+		}
+		b.WriteString("=> " + entry.URL + " " + label + "\n")
+	}
+	return []byte(b.String())
+}
+
+// recordVisit makes parsed the current page of the navigation stack. New
+// visits are added to the saved history, with the title of the current page
+// if it is the page at parsed.
+func (c *Client) recordVisit(parsed *url.URL) {
+	added := c.nav.Visit(parsed)
+	c.saveNavState()
+	if !added {
+		return
+	}
+	title := ""
+	if c.currentPage != nil && c.currentPage.u.String() == parsed.String() {
+		title = c.currentPage.title()
+	}
+	if err := c.savedHistory.Add(parsed.String(), title, time.Now()); err != nil {
+		c.style.WarningMsg("Unable to save history: " + err.Error())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryPruneAndSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h, err := LoadHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	visits := []struct{ u, title string }{
+		{"gemini://a.example/", "Alpha"},
+		{"gemini://b.example/", "Beta\tcapsule"},
+		{"gemini://c.example/", ""},
+		{"gemini://b.example/", "Beta capsule"},
+		{"gopher://d.example/", "Delta"},
+	}
+	for i, v := range visits {
+		if err := h.Add(v.u, v.title, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = LoadHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.entries) != 3 || h.entries[0].URL != "gemini://c.example/" {
+		t.Fatalf("entries after pruning = %+v, want the last 3 visits", h.entries)
+	}
+	if !h.entries[2].Time.Equal(start.Add(4 * time.Minute)) {
+		t.Errorf("time of last entry = %v, want %v", h.entries[2].Time, start.Add(4*time.Minute))
+	}
+
+	var tests = []struct {
+		term string
+		urls []string
+	}{
+		{"BETA", []string{"gemini://b.example/"}},
+		{"example", []string{"gopher://d.example/", "gemini://b.example/", "gemini://c.example/"}},
+		{"alpha", nil},
+	}
+	for _, test := range tests {
+		results := h.Search(test.term)
+		var urls []string
+		for _, entry := range results {
+			urls = append(urls, entry.URL)
+		}
+		if len(urls) != len(test.urls) {
+			t.Errorf("Search(%q) = %v, want %v", test.term, urls, test.urls)
+			continue
+		}
+		for i := range urls {
+			if urls[i] != test.urls[i] {
+				t.Errorf("Search(%q) = %v, want %v", test.term, urls, test.urls)
+				break
+			}
+		}
+	}
+}
+
+func TestHistorySimilar(t *testing.T) {
+	h, err := LoadHistory(filepath.Join(t.TempDir(), "history"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, u := range []string{
+		"gemini://example.org/blog/2023/",
+		"gemini://example.org/blog/2023/old-post.gmi",
+		"gemini://example.org/about.gmi",
+		"gemini://example.org/posts/post.gmi",
+		"gemini://other.example/blog/2023/post.gmi",
+		"gemini://example.org/blog/2023/post.gmi",
+		"gemini://example.org/blog/",
+	} {
+		if err := h.Add(u, "", start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := h.Similar(mustParse("gemini://example.org/blog/2023/post.gmi"), 3)
+	want := []string{
+		"gemini://example.org/blog/2023/old-post.gmi",
+		"gemini://example.org/blog/2023/",
+		"gemini://example.org/blog/",
+	}
+	if len(res) != len(want) {
+		t.Fatalf("Similar() = %v, want %v", res, want)
+	}
+	for i := range want {
+		if res[i].URL != want[i] {
+			t.Errorf("Similar()[%d] = %q, want %q", i, res[i].URL, want[i])
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Named client certificates scoped to URL prefixes
+
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identities are the named client certificates in the identities directory.
+// Each identity is a sub-directory holding a cert.pem and key.pem pair.
+type Identities struct {
+	dir   string
+	certs map[string]tls.Certificate
+	// URL prefix to identity name, activated from the identity command and
+	// saved in scopesPath. The identities table in the config is consulted
+	// as well, see ActiveFor.
+	scopes     map[string]string
+	scopesPath string
+}
+
+// LoadIdentities loads every identity in dir and the scopes saved at
+// scopesPath. A missing directory or file is not an error.
+func LoadIdentities(dir string, scopesPath string) (*Identities, error) {
+	ids := &Identities{
+		dir:        dir,
+		certs:      make(map[string]tls.Certificate),
+		scopes:     make(map[string]string),
+		scopesPath: scopesPath,
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return ids, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		cert, err := loadClientCert(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return ids, fmt.Errorf("identity %s: %w", entry.Name(), err)
+		}
+		if cert.Certificate != nil {
+			ids.certs[entry.Name()] = cert
+		}
+	}
+
+	f, err := os.Open(scopesPath)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return ids, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			ids.scopes[fields[0]] = fields[1]
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// SaveScopes writes the activated scopes to the scopes file
+func (ids *Identities) SaveScopes() error {
+	lines := make([]string, 0, len(ids.scopes))
+	for prefix, name := range ids.scopes {
+		lines = append(lines, prefix+" "+name)
+	}
+	sort.Strings(lines)
+	contents := strings.Join(lines, "\n")
+	if contents != "" {
+		contents += "\n"
+	}
+	return ioutil.WriteFile(ids.scopesPath, []byte(contents), 0644)
+}
+
+// Names returns the names of all loaded identities, sorted
+func (ids *Identities) Names() []string {
+	names := make([]string, 0, len(ids.certs))
+	for name := range ids.certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ActiveFor returns the identity whose scope is the longest prefix of u, out
+// of the activated scopes and those in confScopes. Activated scopes take
+// priority over the config for the same prefix.
+func (ids *Identities) ActiveFor(u string, confScopes map[string]string) (name string, prefix string, fromConfig bool) {
+	for p, n := range confScopes {
+		if strings.HasPrefix(u, p) && len(p) > len(prefix) {
+			name, prefix, fromConfig = n, p, true
+		}
+	}
+	for p, n := range ids.scopes {
+		if strings.HasPrefix(u, p) && len(p) >= len(prefix) {
+			name, prefix, fromConfig = n, p, false
+		}
+	}
+	return
+}
+
+// Activate scopes the identity name to URLs starting with prefix
+func (ids *Identities) Activate(name string, prefix string) bool {
+	if _, ok := ids.certs[name]; !ok {
+		return false
+	}
+	ids.scopes[prefix] = name
+	return true
+}
+
+// Deactivate removes every activated scope that u falls under, returning the
+// number of scopes removed
+func (ids *Identities) Deactivate(u string) (removed int) {
+	for p := range ids.scopes {
+		if strings.HasPrefix(u, p) {
+			delete(ids.scopes, p)
+			removed++
+		}
+	}
+	return
+}
+
+// identityScope returns the URL prefix an identity is scoped to when
+// activated for u: the URL without its query and fragment.
+func identityScope(u *url.URL) string {
+	scope := *u
+	scope.RawQuery = ""
+	scope.ForceQuery = false
+	scope.Fragment = ""
+	return scope.String()
+}
+
+// Key types supported for generated identities
+var identityKeyTypes = []string{"ecdsa", "ed25519", "rsa"}
+
+// Generate creates a self-signed client certificate with the given common
+// name, key type, and lifetime, and saves it as a new identity called name.
+func (ids *Identities) Generate(name string, commonName string, keyType string, lifetime time.Duration) error {
+	if name == "" || strings.ContainsAny(name, " \t/\\") || strings.HasPrefix(name, ".") {
+		return errors.New("invalid identity name: " + name)
+	}
+	if _, ok := ids.certs[name]; ok {
+		return errors.New("identity already exists: " + name)
+	}
+
+	var priv crypto.Signer
+	var err error
+	switch keyType {
+	case "ecdsa":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ed25519":
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return errors.New("unsupported key type: " + keyType)
+	}
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(ids.dir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		return err
+	}
+	ids.certs[name] = cert
+	return nil
+}
+
+// parseLifetime parses a certificate lifetime such as "30d" or "5y". A plain
+// number is taken as days.
+func parseLifetime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	unit := 24 * time.Hour
+	if strings.HasSuffix(s, "y") {
+		unit *= 365
+		s = strings.TrimSuffix(s, "y")
+	} else {
+		s = strings.TrimSuffix(s, "d")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid lifetime, use a number of days (30d) or years (5y)")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// CreateIdentity walks the user through generating a new identity. It
+// returns the name of the new identity and whether it was created.
+func (c *Client) CreateIdentity(suggestedName string) (name string, ok bool) {
+	fmt.Println("Name of the identity:")
+	if name, ok = c.PromptString("name> ", suggestedName); !ok {
+		return
+	}
+	fmt.Println("Common name (CN) of the certificate, which some servers show as your username:")
+	commonName, ok := c.PromptString("common name> ", name)
+	if !ok {
+		return
+	}
+	fmt.Printf("Key type (%s):\n", strings.Join(identityKeyTypes, ", "))
+	keyType, ok := c.PromptString("key type> ", identityKeyTypes[0])
+	if !ok {
+		return
+	}
+	fmt.Println("Lifetime of the certificate, in days (30d) or years (5y):")
+	lifetimeStr, ok := c.PromptString("lifetime> ", "5y")
+	if !ok {
+		return
+	}
+	lifetime, err := parseLifetime(lifetimeStr)
+	if err != nil {
+		c.style.ErrorMsg(err.Error())
+		return name, false
+	}
+	if err := c.identities.Generate(name, commonName, keyType, lifetime); err != nil {
+		c.style.ErrorMsg("Unable to create identity: " + err.Error())
+		return name, false
+	}
+	fmt.Println("Created identity", name, "in", filepath.Join(c.identities.dir, name))
+	return name, true
+}
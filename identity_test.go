@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdentitiesActiveFor(t *testing.T) {
+	ids := &Identities{scopes: map[string]string{
+		"gemini://example.org/app":     "alice",
+		"gemini://example.org/app/bob": "bob",
+	}}
+	conf := map[string]string{
+		"gemini://example.org/":    "carol",
+		"gemini://example.org/app": "dave",
+	}
+
+	var tests = []struct {
+		u          string
+		name       string
+		fromConfig bool
+	}{
+		{"gemini://example.org/", "carol", true},
+		{"gemini://example.org/app", "alice", false},
+		{"gemini://example.org/app/bob/profile", "bob", false},
+		{"gemini://example.org/application", "alice", false},
+		{"gemini://example.com/app", "", false},
+	}
+
+	for _, test := range tests {
+		name, _, fromConfig := ids.ActiveFor(test.u, conf)
+		if name != test.name || fromConfig != test.fromConfig {
+			t.Errorf("ActiveFor(%q) = %q, %v, want %q, %v", test.u, name, fromConfig, test.name, test.fromConfig)
+		}
+	}
+}
+
+func TestIdentitiesGenerate(t *testing.T) {
+	dir := t.TempDir()
+	ids, err := LoadIdentities(dir, filepath.Join(dir, "scopes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyType := range identityKeyTypes {
+		if err := ids.Generate(keyType, "tester", keyType, 24*time.Hour); err != nil {
+			t.Errorf("Generate(%q) = %v", keyType, err)
+		}
+	}
+	if err := ids.Generate("ecdsa", "tester", "ecdsa", 24*time.Hour); err == nil {
+		t.Error("Generate() of an existing identity succeeded")
+	}
+	if err := ids.Generate("../x", "tester", "ecdsa", 24*time.Hour); err == nil {
+		t.Error("Generate() with an invalid name succeeded")
+	}
+
+	reloaded, err := LoadIdentities(dir, filepath.Join(dir, "scopes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(reloaded.Names(), " "); got != "ecdsa ed25519 rsa" {
+		t.Errorf("reloaded identities = %q", got)
+	}
+}
+
+func TestParseLifetime(t *testing.T) {
+	var tests = []struct {
+		s   string
+		res time.Duration
+		ok  bool
+	}{
+		{"30", 30 * 24 * time.Hour, true},
+		{"30d", 30 * 24 * time.Hour, true},
+		{"2Y", 2 * 365 * 24 * time.Hour, true},
+		{"0d", 0, false},
+		{"-1y", 0, false},
+		{"forever", 0, false},
+	}
+
+	for _, test := range tests {
+		res, err := parseLifetime(test.s)
+		if (err == nil) != test.ok || res != test.res {
+			t.Errorf("parseLifetime(%q) = %v, %v, want %v", test.s, res, err, test.res)
+		}
+	}
+}
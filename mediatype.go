@@ -0,0 +1,116 @@
+// Guessing media types of response bodies
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+)
+
+// At most how many bytes of a body are looked at to guess its media type
+const sniffLen = 512
+
+// mediaSignature is the magic bytes found at offset in bodies of mediaType
+type mediaSignature struct {
+	offset    int
+	magic     string
+	mediaType string
+}
+
+// The media types gelim can tell from the first bytes of a body, the ones
+// handlers are most often set up for
+var mediaSignatures = []mediaSignature{
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{8, "WEBP", "image/webp"},
+	{0, "BM", "image/bmp"},
+	{0, "\x00\x00\x01\x00", "image/x-icon"},
+	{0, "ID3", "audio/mpeg"},
+	{0, "\xff\xfb", "audio/mpeg"},
+	{0, "OggS", "application/ogg"},
+	{0, "fLaC", "audio/flac"},
+	{8, "WAVE", "audio/wave"},
+	{8, "AVI ", "video/avi"},
+	{4, "ftyp", "video/mp4"},
+	{0, "\x1a\x45\xdf\xa3", "video/webm"},
+	{0, "%PDF-", "application/pdf"},
+	{0, "%!PS-Adobe-", "application/postscript"},
+	{0, "PK\x03\x04", "application/zip"},
+	{0, "\x1f\x8b\x08", "application/x-gzip"},
+	{0, "Rar!\x1a\x07", "application/x-rar-compressed"},
+	{0, "\xef\xbb\xbf", "text/plain"}, // UTF-8 byte order mark
+}
+
+// detectMediaType guesses the media type of a body from head, its first
+// bytes. Bodies without control characters are text.
+func detectMediaType(head []byte) string {
+	for _, sig := range mediaSignatures {
+		if len(head) >= sig.offset+len(sig.magic) && string(head[sig.offset:sig.offset+len(sig.magic)]) == sig.magic {
+			return sig.mediaType
+		}
+	}
+	trimmed := bytes.ToLower(bytes.TrimLeft(head, " \t\r\n"))
+	if bytes.HasPrefix(trimmed, []byte("<!doctype html")) || bytes.HasPrefix(trimmed, []byte("<html")) {
+		return "text/html"
+	}
+	for _, b := range head {
+		// Tabs, newlines, form feeds, and escapes are common in text
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b || b == 0x7f {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain"
+}
+
+// sniffMediaType guesses the media type of the body read by r from its first
+// bytes, without consuming them. Only the bytes that arrived with the first
+// read are looked at, so that slow servers are not waited for.
+func sniffMediaType(r *bufio.Reader) string {
+	if _, err := r.Peek(1); err != nil {
+		return detectMediaType(nil)
+	}
+	n := r.Buffered()
+	if n > sniffLen {
+		n = sniffLen
+	}
+	head, _ := r.Peek(n)
+	return detectMediaType(head)
+}
+
+// sniff guesses the media type of the body of page, without consuming it
+func (page *Page) sniff() string {
+	if page.body == nil {
+		head := page.bodyBytes
+		if len(head) > sniffLen {
+			head = head[:sniffLen]
+		}
+		return detectMediaType(head)
+	}
+	br := bufio.NewReader(page.body)
+	page.body = readCloser{br, page.body}
+	return sniffMediaType(br)
+}
+
+// mediaTypeByExtension guesses the media type of a file from the extension of
+// its name, or returns an empty string
+func mediaTypeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	for mediaType, preferred := range preferredExtensions {
+		if preferred == ext && !strings.Contains(mediaType, "directory") && mediaType != "gophermap" {
+			return mediaType
+		}
+	}
+	if ext == ".gemini" {
+		return "text/gemini"
+	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	return mediaType
+}
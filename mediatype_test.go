@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDetectMediaType(t *testing.T) {
+	var tests = []struct {
+		head string
+		res  string
+	}{
+		{"", "text/plain"},
+		{"Hello\tworld\r\n", "text/plain"},
+		{"\xef\xbb\xbfText with a BOM", "text/plain"},
+		{"  <!DOCTYPE html>\n<html>", "text/html"},
+		{"\x89PNG\r\n\x1a\n\x00\x00", "image/png"},
+		{"\xff\xd8\xff\xe0", "image/jpeg"},
+		{"GIF89a\x01\x00", "image/gif"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"ID3\x03\x00", "audio/mpeg"},
+		{"%PDF-1.7", "application/pdf"},
+		{"\x00\x01\x02", "application/octet-stream"},
+	}
+
+	for _, test := range tests {
+		if res := detectMediaType([]byte(test.head)); res != test.res {
+			t.Errorf("detectMediaType(%q) = %q, want %q", test.head, res, test.res)
+		}
+	}
+}
+
+func TestSniffMediaTypeSlow(t *testing.T) {
+	r, server := io.Pipe()
+	defer server.Close()
+	go server.Write([]byte("GIF89a"))
+
+	// The server sends nothing more, which should not be waited for
+	sniffed := make(chan string)
+	go func() { sniffed <- sniffMediaType(bufio.NewReader(r)) }()
+	select {
+	case res := <-sniffed:
+		if res != "image/gif" {
+			t.Errorf("sniffMediaType() = %q, want image/gif", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sniffMediaType() waited for more than what was sent")
+	}
+}
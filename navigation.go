@@ -0,0 +1,126 @@
+// Going back and forward between pages visited in a session
+
+package main
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// NavEntry is a page in the navigation stack, with what is needed to return
+// to it as it was left
+type NavEntry struct {
+	u          *url.URL
+	links      []string
+	inputLinks []int
+	linkLines  []int
+	// The line of the link that was followed from this page, to scroll back
+	// to when returning to it
+	line int
+}
+
+// Navigation is the stack of pages visited in a session, with a cursor at
+// the current page. Going back and forward moves the cursor, and visiting a
+// new page drops the pages after the cursor.
+type Navigation struct {
+	entries []*NavEntry
+	pos     int
+}
+
+// Current returns the entry of the current page, or nil if nothing has been
+// visited yet
+func (n *Navigation) Current() *NavEntry {
+	if len(n.entries) == 0 {
+		return nil
+	}
+	return n.entries[n.pos]
+}
+
+// Visit makes u the current page. Nothing is added if u is already the
+// current page, such as when it is reloaded or returned to.
+func (n *Navigation) Visit(u *url.URL) (added bool) {
+	if cur := n.Current(); cur != nil && cur.u.String() == u.String() {
+		return false
+	}
+	if len(n.entries) > 0 {
+		n.entries = n.entries[:n.pos+1]
+		n.pos++
+	}
+	n.entries = append(n.entries, &NavEntry{u: u})
+	return true
+}
+
+// Go moves the cursor to the entry at the 0-based index pos and returns it
+// along with the previous position
+func (n *Navigation) Go(pos int) (entry *NavEntry, prev int, ok bool) {
+	if pos < 0 || pos >= len(n.entries) {
+		return nil, n.pos, false
+	}
+	prev, n.pos = n.pos, pos
+	return n.entries[pos], prev, true
+}
+
+// currentURL returns the URL of the current page, or nil if nothing has been
+// visited yet
+func (c *Client) currentURL() *url.URL {
+	if cur := c.nav.Current(); cur != nil {
+		return cur.u
+	}
+	return nil
+}
+
+// saveNavState keeps the links of the current page in its navigation entry
+func (c *Client) saveNavState() {
+	if cur := c.nav.Current(); cur != nil {
+		cur.links, cur.inputLinks, cur.linkLines = c.links, c.inputLinks, c.linkLines
+	}
+}
+
+// restoreNavState restores the links of entry, and scrolls back to the link
+// that was followed from it when the page is next displayed
+func (c *Client) restoreNavState(entry *NavEntry) {
+	c.links, c.inputLinks, c.linkLines = entry.links, entry.inputLinks, entry.linkLines
+	c.scrollTo = entry.line
+}
+
+// Navigate goes to the page at 0-based index pos of the navigation stack,
+// showing the cached copy of it if there is one. The cursor is moved back if
+// the page could not be fetched.
+func (c *Client) Navigate(pos int) bool {
+	c.saveNavState()
+	entry, prev, ok := c.nav.Go(pos)
+	if !ok {
+		return false
+	}
+	c.restoreNavState(entry)
+	ok = c.showCached(entry.u) || c.HandleParsedURL(entry.u)
+	c.scrollTo = 0
+	if !ok && c.nav.pos == pos {
+		entry, _, _ = c.nav.Go(prev)
+		c.restoreNavState(entry)
+		c.scrollTo = 0
+	}
+	return ok
+}
+
+// rememberLink records that link index was followed from the current page,
+// so that going back to it scrolls to that link
+func (c *Client) rememberLink(index int) {
+	if cur := c.nav.Current(); cur != nil && index >= 1 && index <= len(c.linkLines) {
+		cur.line = c.linkLines[index-1]
+	}
+}
+
+// navSteps parses the number of pages for the back and forward commands,
+// which defaults to 1
+func (c *Client) navSteps(args []string) (steps int, ok bool) {
+	if len(args) == 0 {
+		return 1, true
+	}
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps < 1 {
+		c.style.ErrorMsg(args[0] + ": Invalid number of pages, expected a positive number")
+		return 0, false
+	}
+	return steps, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNavigationVisit(t *testing.T) {
+	var n Navigation
+	urls := func() (res []string) {
+		for _, entry := range n.entries {
+			res = append(res, entry.u.String())
+		}
+		return
+	}
+	check := func(step string, want []string, pos int) {
+		t.Helper()
+		got := urls()
+		if len(got) != len(want) || n.pos != pos {
+			t.Fatalf("%s: entries = %v at %d, want %v at %d", step, got, n.pos, want, pos)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%s: entries = %v at %d, want %v at %d", step, got, n.pos, want, pos)
+			}
+		}
+	}
+
+	if n.Current() != nil {
+		t.Fatal("Current() of empty navigation is not nil")
+	}
+	for _, u := range []string{"gemini://a/", "gemini://b/", "gemini://b/", "gemini://c/"} {
+		n.Visit(mustParse(u))
+	}
+	check("visits", []string{"gemini://a/", "gemini://b/", "gemini://c/"}, 2)
+
+	if _, _, ok := n.Go(3); ok {
+		t.Error("Go(3) = ok past the end")
+	}
+	entry, prev, ok := n.Go(0)
+	if !ok || prev != 2 || entry.u.String() != "gemini://a/" {
+		t.Errorf("Go(0) = %v, %d, %v, want gemini://a/, 2, true", entry, prev, ok)
+	}
+	// Returning to a page does not add it again
+	if n.Visit(mustParse("gemini://a/")) {
+		t.Error("Visit(current page) = true")
+	}
+	check("back", []string{"gemini://a/", "gemini://b/", "gemini://c/"}, 0)
+
+	n.Visit(mustParse("gemini://d/"))
+	check("visit after back", []string{"gemini://a/", "gemini://d/"}, 1)
+}
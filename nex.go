@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"path"
 	"strings"
 )
 
@@ -15,30 +16,28 @@ type NexResponse struct {
 	bodyReaderClosed bool
 	conn             *net.Conn
 	connClosed       bool
-	fileExt          string // From request
 }
 
-// NexParsedURL fetches u and returns a NexResponse
-func NexParsedURL(u *url.URL) (res *NexResponse, err error) {
+// NexParsedURL fetches u using d and returns a NexResponse
+func NexParsedURL(u *url.URL, d *Dialer) (res *NexResponse, err error) {
 	host := u.Host
 	if u.Port() == "" {
 		host += ":1900" // Default port
 	}
 	// Connect to server, no TLS
-	conn, err := net.Dial("tcp", host)
+	dconn, err := d.Dial(host)
 	if err != nil {
 		return
 	}
-	path := u.Path
+	// There is no response header
+	dconn.SetPhase(PhaseBody, d.ReadTimeout, optReadTimeout)
+	var conn net.Conn = dconn
+	reqPath := u.Path
 	if u.Path == "" {
-		path = "/"
-	}
-	fileExt := "/"
-	if !strings.HasSuffix(path, "/") {
-		fileExt = strings.SplitN(path, ".", 2)[1]
+		reqPath = "/"
 	}
 	// Requests are simply file paths
-	conn.Write([]byte(fmt.Sprintf("%s\n", path)))
+	conn.Write([]byte(fmt.Sprintf("%s\n", reqPath)))
 	// Receive and parse response header
 	reader := bufio.NewReader(conn)
 	res = &NexResponse{
@@ -46,63 +45,78 @@ func NexParsedURL(u *url.URL) (res *NexResponse, err error) {
 		bodyReaderClosed: false, // idk
 		conn:             &conn,
 		connClosed:       false,
-		fileExt:          fileExt,
 	}
 	return
 }
 
-func (c *Client) ParseNexDirectoryPage(page *Page) string {
+// nexDirectoryRenderer returns a lineRenderer for Nex directory listings,
+// which adds links to c.links as they are rendered.
+func (c *Client) nexDirectoryRenderer(page *Page) lineRenderer {
 	var linkStyle = c.style.gmiLink.Sprint
-	body := string(page.bodyBytes)
-	rendered := []string{}
-	maxWidth := 0
 
-	for _, line := range strings.Split(body, "\n") {
+	return func(line string) (renderedLine, bool) {
 		if strings.HasSuffix(line, "\r") {
 			line = strings.Trim(line, "\r")
 		}
-		if strings.HasPrefix(line, "=>") {
-			originalLine := line
-			line = strings.TrimSpace(line[2:])
-			if line == "" {
-				// Empty link line
-				rendered = append(rendered, originalLine)
-				continue
-			}
-			bits := strings.Fields(line)
-			parsedLink, err := url.Parse(bits[0])
-			if err != nil {
-				rendered = append(rendered, originalLine)
-				continue
-			}
+		if !strings.HasPrefix(line, "=>") {
+			// Normal paragraph
+			return renderedLine{text: line, width: len(line)}, true
+		}
+		originalLine := line
+		line = strings.TrimSpace(line[2:])
+		if line == "" {
+			// Empty link line
+			return renderedLine{text: originalLine}, true
+		}
+		bits := strings.Fields(line)
+		parsedLink, err := url.Parse(bits[0])
+		if err != nil {
+			return renderedLine{text: originalLine}, true
+		}
 
-			link := page.u.ResolveReference(parsedLink) // link url
-			var label string                            // link text
-			if len(bits) == 1 {
-				label = bits[0]
-			} else {
-				label = strings.Join(bits[1:], " ")
-			}
+		link := page.u.ResolveReference(parsedLink) // link url
+		var label string                            // link text
+		if len(bits) == 1 {
+			label = bits[0]
+		} else {
+			label = strings.Join(bits[1:], " ")
+		}
 
-			c.links = append(c.links, link.String())
-			linkLine := fmt.Sprintf("[%d] ", len(c.links))
-			linkLine += linkStyle(label)
+		c.links = append(c.links, link.String())
+		linkLine := fmt.Sprintf("[%d] ", len(c.links))
+		linkLine += linkStyle(label)
 
-			if link.Scheme != "nex" {
-				linkLine += fmt.Sprintf(" (%s)", link.Scheme)
-			}
-			if len(c.links) < 10 {
-				linkLine = " " + linkLine
-			}
-			rendered = append(rendered, linkLine)
-		} else {
-			// Normal paragraph
-			rendered = append(rendered, line)
-			if len(line) > maxWidth {
-				maxWidth = len(line)
-			}
+		if link.Scheme != "nex" {
+			linkLine += fmt.Sprintf(" (%s)", link.Scheme)
 		}
+		if len(c.links) < 10 {
+			linkLine = " " + linkLine
+		}
+		return renderedLine{text: linkLine}, true
+	}
+}
+
+// nexMediaType returns the media type of a nex page, which is a directory
+// listing if its path ends with a slash. Other pages have no header, so the
+// type is guessed from the file extension, or by sniffing the body if the
+// extension is missing or unknown.
+func nexMediaType(page *Page) string {
+	if page.u.Path == "" || strings.HasSuffix(page.u.Path, "/") {
+		return "nex/directory"
 	}
+	if mediaType := mediaTypeByExtension(path.Base(page.u.Path)); mediaType != "" {
+		return mediaType
+	}
+	return page.sniff()
+}
 
-	return c.Centered(rendered, maxWidth, []int{})
+// fetchNex fetches u, with the media type guessed by nexMediaType
+func (c *Client) fetchNex(u *url.URL) (*Response, error) {
+	res, err := NexParsedURL(u, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{u: u, body: readCloser{res.bodyReader, *res.conn}}
+	mediaType := nexMediaType(page)
+	return &Response{status: StatusSuccess, mediaType: mediaType, body: page.body}, nil
 }
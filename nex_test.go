@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNexMediaType(t *testing.T) {
+	var tests = []struct {
+		u    string
+		body string
+		res  string
+	}{
+		{"nex://example.org", "", "nex/directory"},
+		{"nex://example.org/", "", "nex/directory"},
+		{"nex://example.org/log/", "=> 1.txt\n", "nex/directory"},
+		{"nex://example.org/notes.txt", "notes", "text/plain"},
+		{"nex://example.org/page.gmi", "# Title\n", "text/gemini"},
+		{"nex://example.org/v1.2/page.GMI", "# Title\n", "text/gemini"},
+		{"nex://example.org/cat.png", "", "image/png"},
+		{"nex://example.org/README", "Plain text\n", "text/plain"},
+		{"nex://example.org/v1.0/image", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"nex://example.org/file.unknownext", "\x00\x01\x02", "application/octet-stream"},
+	}
+
+	for _, test := range tests {
+		page := &Page{u: mustParse(test.u), bodyBytes: []byte(test.body)}
+		if res := nexMediaType(page); res != test.res {
+			t.Errorf("nexMediaType(%q) = %q, want %q", test.u, res, test.res)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Fetching URLs of any protocol through a registry of schemes
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// Status is the kind of a response, in terms common to all protocols
+type Status int
+
+const (
+	StatusUnknown Status = iota // The zero Status, not set by any protocol
+	StatusSuccess
+	StatusInput
+	StatusSensitiveInput
+	StatusRedirect
+	StatusFailure
+	StatusCertRequired
+)
+
+// Response is a response of any protocol, as returned by Protocol.Fetch
+type Response struct {
+	status    Status
+	code      int    // The status code sent by the server, 0 if the protocol has none
+	meta      string // Prompt, redirect URL, or error message
+	mediaType string
+	params    map[string]string
+	body      io.ReadCloser // Only set for StatusSuccess, closed by the caller
+	undefined bool          // The protocol does not define code, it was handled by its first digit
+}
+
+// Protocol fetches URLs of a scheme
+type Protocol interface {
+	Fetch(c *Client, u *url.URL) (*Response, error)
+}
+
+// ProtocolFunc is a function that is used as a Protocol
+type ProtocolFunc func(c *Client, u *url.URL) (*Response, error)
+
+func (f ProtocolFunc) Fetch(c *Client, u *url.URL) (*Response, error) {
+	return f(c, u)
+}
+
+// protocols is the registry of built-in protocols by scheme. Other schemes can
+// be opened by external commands set in the schemes config.
+var protocols = map[string]Protocol{
+	"gemini":  ProtocolFunc((*Client).fetchGemini),
+	"spartan": ProtocolFunc((*Client).fetchSpartan),
+	"nex":     ProtocolFunc((*Client).fetchNex),
+	"gopher":  ProtocolFunc((*Client).fetchGopher),
+	"finger":  ProtocolFunc((*Client).fetchFinger),
+	"guppy":   ProtocolFunc((*Client).fetchGuppy),
+	"scroll":  ProtocolFunc((*Client).fetchScroll),
+	"titan": ProtocolFunc(func(c *Client, u *url.URL) (*Response, error) {
+		return nil, errors.New("titan:// URLs are for uploading, use the `upload` command")
+	}),
+}
+
+// SchemeConfig is the configuration of a scheme in the schemes table. At most
+// one of Command, ProxyURL, and Proxy is set.
+type SchemeConfig struct {
+	Command   string // Opens URLs of the scheme, replacing the built-in protocol
+	ProxyURL  string // Gemini URL that fetches URLs of the scheme, such as a web portal
+	Proxy     string // host:port of a gemini server that proxies URLs of the scheme
+	ProxyCert bool   // Send the client certificate for URLs to Proxy
+}
+
+// checkSchemes returns an error if a scheme of schemes is set to be opened in
+// more than one way
+func checkSchemes(schemes map[string]SchemeConfig) error {
+	for scheme, conf := range schemes {
+		set := 0
+		for _, v := range []string{conf.Command, conf.ProxyURL, conf.Proxy} {
+			if v != "" {
+				set++
+			}
+		}
+		if set > 1 {
+			return fmt.Errorf("schemes.%s: only one of command, proxyURL, and proxy can be set", scheme)
+		}
+	}
+	return nil
+}
+
+// schemeCommand returns the command configured to open URLs of scheme, or an
+// empty string
+func (c *Client) schemeCommand(scheme string) string {
+	return c.conf.Schemes[scheme].Command
+}
+
+// proxiedURL returns the URL to fetch u through proxyURL. %s in proxyURL is
+// replaced with u, query-escaped, otherwise u is added as the query.
+func proxiedURL(proxyURL string, u *url.URL) (*url.URL, error) {
+	var proxied string
+	if strings.Contains(proxyURL, "%s") {
+		proxied = strings.ReplaceAll(proxyURL, "%s", queryEscape(u.String()))
+	} else {
+		proxied = strings.TrimSuffix(proxyURL, "?") + "?" + queryEscape(u.String())
+	}
+	parsed, err := url.Parse(proxied)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == u.Scheme || parsed.Host == "" {
+		return nil, errors.New("the proxy must be an absolute URL of another scheme")
+	}
+	return parsed, nil
+}
+
+// externalCommand returns the arguments of command for u. %s in the
+// arguments is replaced with u, otherwise u is added as the last argument.
+func externalCommand(command string, u *url.URL) ([]string, error) {
+	parts, err := shlex.Split(command)
+	if err != nil {
+		return nil, errors.New("could not parse command and arguments: " + command)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("empty command for " + u.Scheme)
+	}
+	replaced := false
+	for i := 1; i < len(parts); i++ {
+		if strings.Contains(parts[i], "%s") {
+			parts[i] = strings.ReplaceAll(parts[i], "%s", u.String())
+			replaced = true
+		}
+	}
+	if !replaced {
+		parts = append(parts, u.String())
+	}
+	return parts, nil
+}
+
+// OpenExternally runs command for u with the terminal attached, so that
+// programs such as telnet and ssh can be used
+func (c *Client) OpenExternally(u *url.URL, command string) error {
+	parts, err := externalCommand(command, u)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestExternalCommand(t *testing.T) {
+	var tests = []struct {
+		command string
+		u       string
+		res     []string
+	}{
+		{"xdg-open", "https://example.org/a?b", []string{"xdg-open", "https://example.org/a?b"}},
+		{"firefox --new-tab %s", "https://example.org/", []string{"firefox", "--new-tab", "https://example.org/"}},
+		{"sh -c 'echo %s | xclip'", "mailto:a@example.org", []string{"sh", "-c", "echo mailto:a@example.org | xclip"}},
+		{"", "telnet://example.org", nil},
+	}
+
+	for _, test := range tests {
+		res, err := externalCommand(test.command, mustParse(test.u))
+		if test.res == nil {
+			if err == nil {
+				t.Errorf("externalCommand(%q) = %q, want error", test.command, res)
+			}
+			continue
+		}
+		if err != nil || strings.Join(res, "\x00") != strings.Join(test.res, "\x00") {
+			t.Errorf("externalCommand(%q, %q) = %q, %v, want %q", test.command, test.u, res, err, test.res)
+		}
+	}
+}
+
+func TestGeminiResponse(t *testing.T) {
+	var tests = []struct {
+		status    int
+		meta      string
+		res       Status
+		mediaType string
+		ok        bool
+	}{
+		{10, "Name?", StatusInput, "", true},
+		{11, "Password?", StatusSensitiveInput, "", true},
+		{20, "", StatusSuccess, "text/gemini", true},
+		{20, "text/plain; charset=utf-8", StatusSuccess, "text/plain", true},
+		{31, "/new", StatusRedirect, "", true},
+		{44, "10", StatusFailure, "", true},
+		{51, "Not found", StatusFailure, "", true},
+		{60, "Certificate required", StatusCertRequired, "", true},
+		{20, ";", StatusSuccess, "", false},
+		{70, "", StatusFailure, "", false},
+	}
+
+	for _, test := range tests {
+		conn, _ := net.Pipe()
+		res, err := geminiResponse(&GeminiResponse{status: test.status, meta: test.meta, conn: tls.Client(conn, &tls.Config{})})
+		if (err == nil) != test.ok {
+			t.Errorf("geminiResponse(%d %q) err = %v, want ok %v", test.status, test.meta, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if res.status != test.res || res.mediaType != test.mediaType || res.code != test.status {
+			t.Errorf("geminiResponse(%d %q) = %+v, want status %d and media type %q", test.status, test.meta, res, test.res, test.mediaType)
+		}
+	}
+	conn, _ := net.Pipe()
+	res, err := geminiResponse(&GeminiResponse{status: 45, meta: "Later", conn: tls.Client(conn, &tls.Config{})})
+	if err != nil || res.status != StatusFailure || !res.undefined {
+		t.Errorf("geminiResponse(45) = %+v, %v, want an undefined failure", res, err)
+	}
+	if res, err := geminiResponse(&GeminiResponse{status: 44, meta: "10", conn: tls.Client(conn, &tls.Config{})}); err != nil || res.undefined {
+		t.Errorf("geminiResponse(44) = %+v, %v, want a defined status", res, err)
+	}
+}
+
+func TestProxiedURL(t *testing.T) {
+	var tests = []struct {
+		proxyURL string
+		u        string
+		res      string
+	}{
+		{"gemini://portal.example/proxy", "https://example.org/a b?q=1", "gemini://portal.example/proxy?https%3A%2F%2Fexample.org%2Fa%2520b%3Fq%3D1"},
+		{"gemini://portal.example/?", "http://example.org/", "gemini://portal.example/?http%3A%2F%2Fexample.org%2F"},
+		{"gemini://portal.example/get/%s/raw", "https://example.org", "gemini://portal.example/get/https%3A%2F%2Fexample.org/raw"},
+		{"https://portal.example/", "https://example.org/", ""},
+		{"/relative", "https://example.org/", ""},
+	}
+
+	for _, test := range tests {
+		res, err := proxiedURL(test.proxyURL, mustParse(test.u))
+		if test.res == "" {
+			if err == nil {
+				t.Errorf("proxiedURL(%q, %q) = %q, want error", test.proxyURL, test.u, res)
+			}
+			continue
+		}
+		if err != nil || res.String() != test.res {
+			t.Errorf("proxiedURL(%q, %q) = %v, %v, want %q", test.proxyURL, test.u, res, err, test.res)
+		}
+	}
+}
+
+func TestCheckSchemes(t *testing.T) {
+	var tests = []struct {
+		schemes map[string]SchemeConfig
+		ok      bool
+	}{
+		{nil, true},
+		{map[string]SchemeConfig{"https": {Command: "firefox"}, "gopher": {Proxy: "proxy.example.org:1965", ProxyCert: true}}, true},
+		{map[string]SchemeConfig{"http": {ProxyURL: "gemini://portal.example.org/proxy"}}, true},
+		{map[string]SchemeConfig{"https": {Command: "firefox", ProxyURL: "gemini://portal.example.org/proxy"}}, false},
+		{map[string]SchemeConfig{"gopher": {ProxyURL: "gemini://portal.example.org/proxy", Proxy: "proxy.example.org:1965"}}, false},
+	}
+
+	for _, test := range tests {
+		if err := checkSchemes(test.schemes); (err == nil) != test.ok {
+			t.Errorf("checkSchemes(%v) = %v, want ok %v", test.schemes, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Following redirects, and permanent redirects remembered across sessions
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Redirects followed for a single request are aborted after this many, even
+// if maxRedirects is negative
+const maxRedirectChain = 100
+
+// RedirectChain is the URLs visited while following redirects for the last
+// request, starting with the requested URL
+type RedirectChain struct {
+	urls []string
+	// Redirects followed since the user last confirmed one
+	unconfirmed int
+}
+
+// start begins a new chain at u
+func (r *RedirectChain) start(u *url.URL) {
+	r.urls = []string{u.String()}
+	r.unconfirmed = 0
+}
+
+// contains returns whether u was already visited in the chain
+func (r *RedirectChain) contains(u *url.URL) bool {
+	for _, visited := range r.urls {
+		if visited == u.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// redirects returns the number of redirects in the chain
+func (r *RedirectChain) redirects() int {
+	if len(r.urls) == 0 {
+		return 0
+	}
+	return len(r.urls) - 1
+}
+
+// show prints the URLs of the chain
+func (r *RedirectChain) show() {
+	for i, u := range r.urls {
+		if i == 0 {
+			fmt.Println(i, u, "(requested)")
+			continue
+		}
+		fmt.Println(i, u)
+	}
+}
+
+// nextRedirect returns the destination of the redirect res returned for from
+// and adds it to the chain, and whether it should be followed. Redirects that
+// lead back to a URL of the chain are not followed, and those to another host
+// or scheme are only followed once confirmed, as are redirects beyond
+// maxRedirects. Permanent redirects are recorded once they are followed.
+func (c *Client) nextRedirect(from *url.URL, res *Response) (*url.URL, bool) {
+	if res.undefined {
+		c.style.WarningMsg(fmt.Sprintf("Undefined status code %v", res.code))
+	}
+	if res.meta == "" {
+		c.style.ErrorMsg("Redirect with no URL")
+		return nil, false
+	}
+	dest, err := from.Parse(res.meta)
+	if err != nil {
+		c.style.ErrorMsg(fmt.Sprintf("Invalid redirect URL %q returned by server", res.meta))
+		return nil, false
+	}
+	if c.redir.contains(dest) {
+		c.style.ErrorMsg("Redirect loop, " + dest.String() + " was already visited:")
+		c.redir.show()
+		return nil, false
+	}
+	if c.redir.redirects() >= maxRedirectChain {
+		c.style.ErrorMsg(fmt.Sprintf("Redirected %d times, aborting", c.redir.redirects()))
+		fmt.Println("See `redirects` for the URLs visited")
+		return nil, false
+	}
+
+	confirm := false
+	def := true
+	switch {
+	case dest.Scheme != from.Scheme:
+		c.style.WarningMsg("Redirect to another protocol, from " + from.Scheme + " to " + dest.Scheme)
+		confirm, def = true, false
+	case dest.Hostname() != from.Hostname():
+		c.style.WarningMsg("Redirect to another host, from " + from.Hostname() + " to " + dest.Hostname())
+		confirm, def = true, false
+	case c.conf.MaxRedirects == 0:
+		confirm = true
+	case c.conf.MaxRedirects > 0 && c.redir.unconfirmed >= c.conf.MaxRedirects:
+		c.style.WarningMsg(fmt.Sprintf("Max redirects of %d reached", c.conf.MaxRedirects))
+		confirm = true
+	}
+	if confirm {
+		if opt, ok := c.PromptRedirect(dest.String(), def); !ok || !opt {
+			return nil, false
+		}
+		c.redir.unconfirmed = 0
+	} else {
+		c.redir.unconfirmed++
+	}
+	if res.code == 31 {
+		// Only moves that were followed are remembered, so that later visits
+		// never skip the checks above
+		c.recordPermanentRedirect(from, dest)
+		fmt.Println("Moved permanently to", dest)
+	}
+	c.redir.urls = append(c.redir.urls, dest.String())
+	return dest, true
+}
+
+// PermanentRedirects are the pages that moved permanently (status 31), saved
+// in a file with one tab-separated "old new" pair of URLs per line
+type PermanentRedirects struct {
+	path    string
+	targets map[string]string // Old URL to new URL
+}
+
+// LoadPermanentRedirects reads the redirects file at path. A missing file is
+// not an error, and lines that could not be parsed are skipped.
+func LoadPermanentRedirects(path string) (*PermanentRedirects, error) {
+	r := &PermanentRedirects{path: path, targets: make(map[string]string)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) == 2 && fields[0] != "" && fields[1] != "" {
+			r.targets[fields[0]] = fields[1]
+		}
+	}
+	return r, scanner.Err()
+}
+
+// Add records that from moved to to, and saves the file. A recorded move the
+// other way, which the server has undone, is forgotten.
+func (r *PermanentRedirects) Add(from string, to string) error {
+	if from == to || r.targets[from] == to {
+		return nil
+	}
+	if dest, _ := r.Resolve(to); dest == from {
+		delete(r.targets, to)
+	}
+	r.targets[from] = to
+	return r.Save()
+}
+
+// Remove forgets that from moved, and saves the file. It returns whether from
+// was recorded.
+func (r *PermanentRedirects) Remove(from string) (bool, error) {
+	if _, ok := r.targets[from]; !ok {
+		return false, nil
+	}
+	delete(r.targets, from)
+	return true, r.Save()
+}
+
+// Resolve returns where u has moved to, following pages that moved again. It
+// returns false if u has not moved.
+func (r *PermanentRedirects) Resolve(u string) (string, bool) {
+	seen := map[string]bool{u: true}
+	dest, moved := u, false
+	for {
+		next, ok := r.targets[dest]
+		if !ok || seen[next] {
+			return dest, moved
+		}
+		seen[next] = true
+		dest, moved = next, true
+	}
+}
+
+// Sources returns the old URLs, sorted
+func (r *PermanentRedirects) Sources() []string {
+	sources := make([]string, 0, len(r.targets))
+	for from := range r.targets {
+		sources = append(sources, from)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// Save writes the redirects file
+func (r *PermanentRedirects) Save() error {
+	var b strings.Builder
+	for _, from := range r.Sources() {
+		b.WriteString(from + "\t" + r.targets[from] + "\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, []byte(b.String()), 0600)
+}
+
+// Rewrite replaces the URLs of bookmarks that moved, and returns how many
+// were changed
+func (b *Bookmarks) Rewrite(r *PermanentRedirects) (n int) {
+	for _, entry := range b.Entries() {
+		if dest, ok := r.Resolve(entry.URL); ok {
+			b.lines[entry.line] = strings.TrimSpace("=> " + dest + " " + entry.Title)
+			n++
+		}
+	}
+	return
+}
+
+// Rewrite replaces the URLs of history entries that moved, in the history
+// file, and returns how many were changed. The file is read again because
+// other sessions may have added entries to it.
+func (h *History) Rewrite(r *PermanentRedirects) (n int, err error) {
+	entries, err := readHistoryFile(h.path)
+	if err != nil {
+		return 0, err
+	}
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		if dest, ok := r.Resolve(entry.URL); ok {
+			entries[i].URL = dest
+			n++
+		}
+		lines[i] = entries[i].String() + "\n"
+	}
+	h.entries = entries
+	if n == 0 {
+		return 0, nil
+	}
+	return n, ioutil.WriteFile(h.path, []byte(strings.Join(lines, "")), 0600)
+}
+
+// followPermanentRedirect returns where parsed has moved to according to the
+// recorded permanent redirects, adding it to the redirect chain, or parsed if
+// it has not moved. It returns false if the move leads back to a URL of the
+// chain.
+func (c *Client) followPermanentRedirect(parsed *url.URL) (*url.URL, bool) {
+	dest, ok := c.permanentRedirects.Resolve(parsed.String())
+	if !ok {
+		return parsed, true
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return parsed, true
+	}
+	if c.redir.contains(u) {
+		c.style.ErrorMsg("Redirect loop, " + dest + " was already visited:")
+		c.redir.show()
+		return nil, false
+	}
+	fmt.Println("Going to", dest, "which", parsed, "moved to")
+	c.redir.urls = append(c.redir.urls, dest)
+	return u, true
+}
+
+// recordPermanentRedirect remembers that from moved to to
+func (c *Client) recordPermanentRedirect(from *url.URL, to *url.URL) {
+	if err := c.permanentRedirects.Add(from.String(), to.String()); err != nil {
+		c.style.WarningMsg("Unable to save redirect: " + err.Error())
+	}
+}
+
+// RewriteMoved replaces the URLs of pages that moved in the bookmarks, the
+// history, and the tour list
+func (c *Client) RewriteMoved() {
+	if b, ok := c.loadBookmarks(); ok {
+		if n := b.Rewrite(c.permanentRedirects); n > 0 {
+			if err := b.Save(); err != nil {
+				c.style.ErrorMsg("Unable to save bookmarks: " + err.Error())
+			} else {
+				fmt.Println("Rewrote", n, "bookmark(s)")
+			}
+		}
+	}
+	if n, err := c.savedHistory.Rewrite(c.permanentRedirects); err != nil {
+		c.style.ErrorMsg("Unable to rewrite history: " + err.Error())
+	} else if n > 0 {
+		fmt.Println("Rewrote", n, "history entries")
+	}
+	n := 0
+	for i, u := range c.tourLinks {
+		if dest, ok := c.permanentRedirects.Resolve(u); ok {
+			c.tourLinks[i] = dest
+			n++
+		}
+	}
+	if n > 0 {
+		fmt.Println("Rewrote", n, "tour item(s)")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPermanentRedirects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redirects")
+	r, err := LoadPermanentRedirects(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, move := range [][2]string{
+		{"gemini://a.example/old", "gemini://a.example/new"},
+		{"gemini://a.example/new", "gemini://b.example/"},
+		{"gemini://c.example/", "gemini://d.example/"},
+		{"gemini://d.example/", "gemini://c.example/"},
+	} {
+		if err := r.Add(move[0], move[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err = LoadPermanentRedirects(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		u     string
+		res   string
+		moved bool
+	}{
+		{"gemini://a.example/old", "gemini://b.example/", true},
+		{"gemini://a.example/new", "gemini://b.example/", true},
+		{"gemini://b.example/", "gemini://b.example/", false},
+		// The move from c to d was undone
+		{"gemini://c.example/", "gemini://c.example/", false},
+		{"gemini://d.example/", "gemini://c.example/", true},
+	}
+	for _, test := range tests {
+		res, moved := r.Resolve(test.u)
+		if res != test.res || moved != test.moved {
+			t.Errorf("Resolve(%q) = %q, %v, want %q, %v", test.u, res, moved, test.res, test.moved)
+		}
+	}
+
+	if ok, err := r.Remove("gemini://a.example/new"); !ok || err != nil {
+		t.Errorf("Remove() = %v, %v, want true", ok, err)
+	}
+	if res, _ := r.Resolve("gemini://a.example/old"); res != "gemini://a.example/new" {
+		t.Errorf("Resolve() after Remove() = %q, want gemini://a.example/new", res)
+	}
+}
+
+func TestRewriteMoved(t *testing.T) {
+	dir := t.TempDir()
+	r, _ := LoadPermanentRedirects(filepath.Join(dir, "redirects"))
+	r.Add("gemini://a.example/old", "gemini://a.example/new")
+
+	b, _ := LoadBookmarks(filepath.Join(dir, "bookmarks.gmi"))
+	b.Add("gemini://a.example/old", "Old page")
+	b.Add("gemini://b.example/", "")
+	if n := b.Rewrite(r); n != 1 {
+		t.Errorf("Bookmarks.Rewrite() = %d, want 1", n)
+	}
+	if entries := b.Entries(); entries[0].URL != "gemini://a.example/new" || entries[0].Title != "Old page" {
+		t.Errorf("rewritten bookmark = %+v, want the new URL with the title kept", entries[0])
+	}
+
+	h, _ := LoadHistory(filepath.Join(dir, "history"), 10)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.Add("gemini://a.example/old", "Old page", now)
+	h.Add("gemini://b.example/", "", now)
+	if n, err := h.Rewrite(r); n != 1 || err != nil {
+		t.Errorf("History.Rewrite() = %d, %v, want 1", n, err)
+	}
+	reloaded, _ := LoadHistory(filepath.Join(dir, "history"), 10)
+	if reloaded.entries[0].URL != "gemini://a.example/new" || reloaded.entries[0].Title != "Old page" {
+		t.Errorf("rewritten history entry = %+v, want the new URL with the title kept", reloaded.entries[0])
+	}
+}
+
+func TestNextRedirect(t *testing.T) {
+	dir := t.TempDir()
+	r, _ := LoadPermanentRedirects(filepath.Join(dir, "redirects"))
+	c := &Client{style: &DefaultStyle, conf: &Config{MaxRedirects: -1}, permanentRedirects: r}
+	start, _ := url.Parse("gemini://a.example/1")
+	c.redir.start(start)
+
+	var tests = []struct {
+		from string
+		res  Response
+		dest string // Empty if not followed
+	}{
+		{"gemini://a.example/1", Response{code: 30, meta: "2"}, "gemini://a.example/2"},
+		{"gemini://a.example/2", Response{code: 31, meta: "/3"}, "gemini://a.example/3"},
+		{"gemini://a.example/3", Response{code: 30, meta: ""}, ""},
+		// Back to a URL of the chain
+		{"gemini://a.example/3", Response{code: 30, meta: "/1"}, ""},
+		{"gemini://a.example/3", Response{code: 30, meta: "gemini://a.example/2"}, ""},
+		{"gemini://a.example/3", Response{code: 31, meta: "/1"}, ""},
+	}
+	for _, test := range tests {
+		from, _ := url.Parse(test.from)
+		dest, ok := c.nextRedirect(from, &test.res)
+		if ok != (test.dest != "") || ok && dest.String() != test.dest {
+			t.Errorf("nextRedirect(%s, %q) = %v, %v, want %q", test.from, test.res.meta, dest, ok, test.dest)
+		}
+	}
+	if c.redir.redirects() != 2 || c.redir.urls[2] != "gemini://a.example/3" {
+		t.Errorf("chain = %v, want the 2 followed redirects", c.redir.urls)
+	}
+	if moved, _ := r.Resolve("gemini://a.example/2"); moved != "gemini://a.example/3" {
+		t.Errorf("permanent redirect of /2 = %q, want /3", moved)
+	}
+	if moved, ok := r.Resolve("gemini://a.example/3"); ok {
+		t.Errorf("permanent redirect of /3 = %q, want none since it was not followed", moved)
+	}
+}
+
+func TestFollowPermanentRedirect(t *testing.T) {
+	dir := t.TempDir()
+	r, _ := LoadPermanentRedirects(filepath.Join(dir, "redirects"))
+	r.Add("gemini://a.example/old", "gemini://a.example/new")
+	c := &Client{style: &DefaultStyle, conf: &Config{MaxRedirects: -1}, permanentRedirects: r}
+
+	start, _ := url.Parse("gemini://a.example/old")
+	c.redir.start(start)
+	if u, ok := c.followPermanentRedirect(start); !ok || u.String() != "gemini://a.example/new" {
+		t.Errorf("followPermanentRedirect(/old) = %v, %v, want /new", u, ok)
+	}
+	if c.redir.redirects() != 1 {
+		t.Errorf("chain = %v, want the move added", c.redir.urls)
+	}
+
+	// A live redirect from /new back to /old, which moved to /new
+	newURL, _ := url.Parse("gemini://a.example/new")
+	c.redir.start(newURL)
+	if dest, ok := c.nextRedirect(newURL, &Response{code: 30, meta: "/old"}); !ok {
+		t.Fatalf("nextRedirect(/new, /old) = %v, %v, want followed", dest, ok)
+	}
+	if u, ok := c.followPermanentRedirect(start); ok {
+		t.Errorf("followPermanentRedirect(/old) = %v, want a loop back to /new", u)
+	}
+}
@@ -0,0 +1,106 @@
+// URL Handler for the scroll protocol
+//
+// Scroll is like gemini, over TLS with the same response header, but requests
+// also list the languages the client prefers, documents are served as
+// text/scroll, a variant of gemtext, and the metadata of a document can be
+// requested on its own.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// scrollRequest returns the request line for u, with languages as the comma
+// separated list of preferred languages. Metadata requests have the URL
+// prefixed with "+".
+func scrollRequest(u url.URL, languages []string, metadata bool) string {
+	u.Fragment = ""
+	request := u.String() + " " + strings.Join(languages, ",") + "\r\n"
+	if metadata {
+		request = "+" + request
+	}
+	return request
+}
+
+// ScrollParsedURL fetches u using d, asking for documents in languages, or
+// only their metadata, and returns the response. The server certificate is
+// checked against hosts before the request is sent.
+func ScrollParsedURL(u url.URL, languages []string, metadata bool, cert tls.Certificate, hosts *KnownHosts, d *Dialer) (*GeminiResponse, error) {
+	request := scrollRequest(u, languages, metadata)
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "5699") // Default port
+	}
+	return geminiRequest(u, request, nil, cert, hosts, d)
+}
+
+// scrollResponse converts res, a scroll response, to a Response. Scroll
+// defines more status codes than gemini in the same groups, so they are
+// mapped by their first digit and none of them is undefined.
+func scrollResponse(res *GeminiResponse) (*Response, error) {
+	r, err := geminiResponse(res)
+	if err != nil {
+		return nil, err
+	}
+	r.undefined = false
+	return r, nil
+}
+
+// fetchScroll fetches the document at u in the languages from the config
+func (c *Client) fetchScroll(u *url.URL) (*Response, error) {
+	res, err := ScrollParsedURL(*u, c.conf.ScrollLanguages, false, c.getClientCert(u), c.knownHosts, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	return scrollResponse(res)
+}
+
+// ScrollMetadata requests the metadata of the scroll document at u and prints
+// it, without fetching the document. It returns whether it was successful.
+func (c *Client) ScrollMetadata(u *url.URL) bool {
+	if u.Scheme != "scroll" {
+		c.style.ErrorMsg("Metadata can only be requested for scroll:// URLs")
+		return false
+	}
+	res, err := c.interruptible(func() (*Response, error) {
+		res, err := ScrollParsedURL(*u, c.conf.ScrollLanguages, true, c.getClientCert(u), c.knownHosts, c.dialer)
+		if err != nil {
+			return nil, err
+		}
+		return scrollResponse(res)
+	})
+	if err == ErrInterrupted {
+		c.style.WarningMsg("Cancelled")
+		return false
+	}
+	if err != nil {
+		c.style.ErrorMsg("Unable to request metadata: " + err.Error())
+		return false
+	}
+	switch res.status {
+	case StatusSuccess:
+		defer res.body.Close()
+		var body io.Reader = res.body
+		if c.conf.MaxBodySize > 0 {
+			body = &limitedReader{r: body, remaining: int64(c.conf.MaxBodySize) << 20}
+		}
+		if _, err := io.Copy(os.Stdout, body); err != nil {
+			fmt.Println()
+			c.style.ErrorMsg("Unable to read metadata: " + err.Error())
+			return false
+		}
+		return true
+	case StatusRedirect:
+		c.style.PrintStatus(res.code, res.meta)
+		fmt.Println("Use `scrollmeta` on the new URL to see its metadata.")
+	default:
+		c.style.PrintStatus(res.code, res.meta)
+	}
+	return false
+}
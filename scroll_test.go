@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestScrollRequest(t *testing.T) {
+	var tests = []struct {
+		u         string
+		languages []string
+		metadata  bool
+		res       string
+	}{
+		{"scroll://example.org/", []string{"en"}, false, "scroll://example.org/ en\r\n"},
+		{"scroll://example.org:5700/doc.scroll?q#top", []string{"fr", "en"}, false, "scroll://example.org:5700/doc.scroll?q fr,en\r\n"},
+		{"scroll://example.org", nil, false, "scroll://example.org \r\n"},
+		{"scroll://example.org/doc.scroll", []string{"en"}, true, "+scroll://example.org/doc.scroll en\r\n"},
+	}
+
+	for _, test := range tests {
+		res := scrollRequest(*mustParse(test.u), test.languages, test.metadata)
+		if res != test.res {
+			t.Errorf("scrollRequest(%q, %v, %v) = %q, want %q", test.u, test.languages, test.metadata, res, test.res)
+		}
+	}
+}
+
+func TestScrollResponse(t *testing.T) {
+	for _, status := range []int{21, 32, 45} {
+		conn, _ := net.Pipe()
+		res, err := scrollResponse(&GeminiResponse{status: status, meta: "text/scroll", conn: tls.Client(conn, &tls.Config{})})
+		if err != nil || res.undefined {
+			t.Errorf("scrollResponse(%d) = %+v, %v, want a defined status", status, res, err)
+		}
+	}
+	conn, _ := net.Pipe()
+	if _, err := scrollResponse(&GeminiResponse{status: 70, conn: tls.Client(conn, &tls.Config{})}); err == nil {
+		t.Error("scrollResponse(70) returned no error")
+	}
+}
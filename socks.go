@@ -0,0 +1,101 @@
+// Connecting through SOCKS5 proxies (RFC 1928), such as a local Tor daemon
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Messages for the reply codes of a SOCKS5 server
+var socksReplies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksProxyFor returns the proxy ("host:port") in proxies for hostname. An
+// exact match is preferred, then the longest matching pattern, like handlers.
+func socksProxyFor(proxies map[string]string, hostname string) string {
+	proxy, _ := handlerFor(proxies, hostname)
+	return proxy
+}
+
+// socksConnect asks the SOCKS5 server on conn to connect to host ("host:port").
+// The hostname is sent as is, for the proxy to resolve, so that names such as
+// .onion addresses work.
+func socksConnect(conn net.Conn, host string) error {
+	hostname, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.New("invalid port " + portStr)
+	}
+	if len(hostname) > 255 {
+		return errors.New("hostname too long for SOCKS5: " + hostname)
+	}
+
+	// Greeting, offering no authentication only
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return errors.New("the proxy is not a SOCKS5 server")
+	}
+	if reply[1] != 0 {
+		return errors.New("the SOCKS5 proxy requires authentication, which is not supported")
+	}
+
+	// Connect request, with the address as a domain name
+	request := []byte{5, 1, 0, 3, byte(len(hostname))}
+	request = append(request, hostname...)
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		msg, ok := socksReplies[header[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown error %d", header[1])
+		}
+		return fmt.Errorf("SOCKS5 proxy could not connect to %s: %s", host, msg)
+	}
+	// The bound address and port, which are not needed
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0]) + 2
+	default:
+		return errors.New("invalid reply from SOCKS5 proxy")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// socksServer is a SOCKS5 proxy that accepts one connection, records the
+// requested address, and answers as the target server itself
+func socksServer(t *testing.T, reply byte) (addr string, requested chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	requested = make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		conn.Write([]byte{5, 0})
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(conn, header); err != nil || header[3] != 3 {
+			return
+		}
+		rest := make([]byte, int(header[4])+2)
+		if _, err := io.ReadFull(conn, rest); err != nil {
+			return
+		}
+		port := int(rest[len(rest)-2])<<8 | int(rest[len(rest)-1])
+		requested <- net.JoinHostPort(string(rest[:len(rest)-2]), strconv.Itoa(port))
+		conn.Write([]byte{5, reply, 0, 1, 127, 0, 0, 1, 0, 0})
+		if reply != 0 {
+			return
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Write([]byte("echo " + line))
+	}()
+	return l.Addr().String(), requested
+}
+
+func TestDialSocks(t *testing.T) {
+	proxy, requested := socksServer(t, 0)
+	d := &Dialer{
+		ConnectTimeout: time.Second,
+		ReadTimeout:    time.Second,
+		SocksProxies:   map[string]string{"*.onion": proxy},
+	}
+	conn, err := d.Dial("example.onion:1965")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := <-requested; got != "example.onion:1965" {
+		t.Errorf("proxy was asked for %q, want example.onion:1965", got)
+	}
+	conn.Write([]byte("hello\n"))
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	if line != "echo hello\n" {
+		t.Errorf("read %q through the proxy, want %q", line, "echo hello\n")
+	}
+
+	if _, err := d.DialUDP("example.onion:6775"); err == nil {
+		t.Error("DialUDP() through a SOCKS5 proxy succeeded")
+	}
+}
+
+func TestDialSocksRefused(t *testing.T) {
+	proxy, _ := socksServer(t, 5)
+	d := &Dialer{ConnectTimeout: time.Second, SocksProxies: map[string]string{"*": proxy}}
+	_, err := d.Dial("example.org:70")
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Dial() err = %v, want connection refused", err)
+	}
+}
+
+func TestSocksProxyFor(t *testing.T) {
+	proxies := map[string]string{
+		"*":             "127.0.0.1:1080",
+		"*.onion":       "127.0.0.1:9050",
+		"local.example": "",
+		"*.lan.example": "10.0.0.1:1080",
+	}
+	var tests = []struct {
+		hostname string
+		res      string
+	}{
+		{"abc.onion", "127.0.0.1:9050"},
+		{"a.b.onion", "127.0.0.1:9050"},
+		{"example.org", "127.0.0.1:1080"},
+		{"local.example", ""},
+		{"printer.lan.example", "10.0.0.1:1080"},
+	}
+
+	for _, test := range tests {
+		if res := socksProxyFor(proxies, test.hostname); res != test.res {
+			t.Errorf("socksProxyFor(%q) = %q, want %q", test.hostname, res, test.res)
+		}
+	}
+	if res := socksProxyFor(nil, "example.org"); res != "" {
+		t.Errorf("socksProxyFor(nil) = %q, want no proxy", res)
+	}
+}
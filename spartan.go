@@ -1,11 +1,21 @@
+// URL Handler for the spartan protocol
+//
+// Requests carry a data block after the request line, which is sent for
+// input links and uploads, and is empty otherwise.
+
 package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -19,50 +29,190 @@ type SpartanResponse struct {
 	connClosed       bool
 }
 
-// SpartanParsedURL fetches u and returns a SpartanResponse
-func SpartanParsedURL(u *url.URL) (res *SpartanResponse, err error) {
+// parseSpartanHeader parses the status line of a spartan response. The meta
+// may be missing, the status must be a single digit.
+func parseSpartanHeader(header string) (status int, meta string, err error) {
+	header = strings.TrimRight(header, "\r\n")
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts[0]) != 1 {
+		return 0, "", fmt.Errorf("invalid response header %q", header)
+	}
+	status, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid response header %q", header)
+	}
+	if len(parts) == 2 {
+		meta = parts[1]
+	}
+	return status, meta, nil
+}
+
+// spartanRequest returns the request line for sending size bytes of data to u
+func spartanRequest(u *url.URL, size int64) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s %s %d\r\n", u.Hostname(), path, size)
+}
+
+// spartanQuery returns the data block for the query of u, which is sent
+// percent-decoded. A "+" is kept as is, unlike in HTML forms.
+func spartanQuery(u *url.URL) ([]byte, error) {
+	data, err := url.PathUnescape(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err)
+	}
+	return []byte(data), nil
+}
+
+// SpartanParsedURL sends size bytes read from body as the data block of a
+// request for u using d, and returns a SpartanResponse
+func SpartanParsedURL(u *url.URL, body io.Reader, size int64, d *Dialer) (res *SpartanResponse, err error) {
 	host := u.Host
 	if u.Port() == "" {
 		host += ":300"
 	}
 	// Connect to server
-	conn, err := net.Dial("tcp", host)
+	dconn, err := d.Dial(host)
 	if err != nil {
 		return
 	}
-	// defer conn.Close()
+	var conn net.Conn = dconn
 	// Send request
-	path := u.Path
-	if u.Path == "" {
-		path = "/"
+	if _, err = conn.Write([]byte(spartanRequest(u, size))); err != nil {
+		conn.Close()
+		return nil, err
 	}
-	input, err := url.QueryUnescape(u.RawQuery)
-	if err != nil {
-		return
+	if size > 0 {
+		if _, err = io.CopyN(conn, body, size); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error sending data: %s", err)
+		}
 	}
-	fmt.Fprintf(conn, "%s %s %d\r\n", u.Hostname(), path, len(input))
-	conn.Write([]byte(input))
 	// Receive and parse response header
 	reader := bufio.NewReader(conn)
 	header, err := reader.ReadString(byte('\n'))
 	if err != nil {
+		conn.Close()
+		var timeout *TimeoutError
+		if errors.As(err, &timeout) {
+			return nil, err
+		}
 		return nil, errors.New("error reading response header")
 	}
-	// Parse header
-	statusParts := strings.SplitN(header, " ", 2)
-	status, err := strconv.Atoi(statusParts[0])
+	dconn.SetPhase(PhaseBody, d.ReadTimeout, optReadTimeout)
+	status, meta, err := parseSpartanHeader(header)
 	if err != nil {
-		err = errors.New("invalid response header")
-		return
+		conn.Close()
+		return nil, err
 	}
-	meta := strings.Trim(statusParts[1], "\r\n")
 	res = &SpartanResponse{
-		status:           status,
-		meta:             meta,
-		bodyReader:       reader,
-		bodyReaderClosed: false, // idk
-		conn:             &conn,
-		connClosed:       false,
+		status:     status,
+		meta:       meta,
+		bodyReader: reader,
+		conn:       &conn,
 	}
 	return
 }
+
+// fetchSpartan fetches u, whose query is sent as the data block
+func (c *Client) fetchSpartan(u *url.URL) (*Response, error) {
+	data, err := spartanQuery(u)
+	if err != nil {
+		return nil, err
+	}
+	return c.fetchSpartanData(u, bytes.NewReader(data), int64(len(data)))
+}
+
+// fetchSpartanData fetches u, sending size bytes read from body as the data
+// block
+func (c *Client) fetchSpartanData(u *url.URL, body io.Reader, size int64) (*Response, error) {
+	res, err := SpartanParsedURL(u, body, size, c.dialer)
+	if err != nil {
+		return nil, err
+	}
+	r := &Response{code: res.status, meta: res.meta}
+	switch res.status {
+	case 2:
+		mediaType, params, err := ParseMeta(res.meta)
+		if err != nil {
+			(*res.conn).Close()
+			return nil, fmt.Errorf("Unable to parse header meta\"%s\": %s", res.meta, err)
+		}
+		r.status, r.mediaType, r.params = StatusSuccess, mediaType, params
+		r.body = readCloser{res.bodyReader, *res.conn}
+		return r, nil
+	case 3:
+		// The meta is an absolute path on the same host, resolved against u
+		// when the redirect is followed
+		r.status = StatusRedirect
+	case 4, 5:
+		r.status = StatusFailure
+	default:
+		(*res.conn).Close()
+		return nil, fmt.Errorf("Invalid status code %d", res.status)
+	}
+	(*res.conn).Close()
+	return r, nil
+}
+
+// SpartanUpload sends size bytes read from body as the data block of a
+// request for the spartan URL u, then follows redirects and displays the
+// response like for any other request. It returns whether it was successful.
+func (c *Client) SpartanUpload(u *url.URL, body io.Reader, size int64) bool {
+	c.redir.start(u)
+	res, err := c.interruptible(func() (*Response, error) {
+		return c.fetchSpartanData(u, body, size)
+	})
+	if err == ErrInterrupted {
+		c.style.WarningMsg("Cancelled")
+		return false
+	}
+	if err != nil {
+		c.style.ErrorMsg("Unable to send data: " + err.Error())
+		return false
+	}
+	return c.handleRedirects(u, res)
+}
+
+// SpartanInput asks for the data to send to u, the URL of a spartan input
+// link. A single line is read at the prompt, and an empty line opens $EDITOR
+// to write multiple lines instead.
+func (c *Client) SpartanInput(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		c.style.ErrorMsg("Invalid url")
+		return false
+	}
+	fmt.Println("Leave empty to write multiple lines in $EDITOR, or see `help upload` to send a file")
+	line, ok := c.PromptString("INPUT> ", "")
+	if !ok {
+		return false
+	}
+	if line != "" {
+		return c.SpartanUpload(parsed, strings.NewReader(line), int64(len(line)))
+	}
+
+	dir, err := c.tempDir()
+	if err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	path := uniquePath(filepath.Join(dir, "input.txt"))
+	if err := c.OpenEditor(path); err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		c.style.WarningMsg("Nothing was written, not sending")
+		return false
+	}
+	if err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	os.Remove(path)
+	return c.SpartanUpload(parsed, bytes.NewReader(data), int64(len(data)))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseSpartanHeader(t *testing.T) {
+	var tests = []struct {
+		header string
+		status int
+		meta   string
+		ok     bool
+	}{
+		{"2 text/gemini\r\n", 2, "text/gemini", true},
+		{"3 /new/path\r\n", 3, "/new/path", true},
+		{"4 not found\n", 4, "not found", true},
+		{"5\r\n", 5, "", true},
+		{"2\r\n", 2, "", true},
+		{"20 text/gemini\r\n", 0, "", false},
+		{"\r\n", 0, "", false},
+		{"x text/gemini\r\n", 0, "", false},
+	}
+
+	for _, test := range tests {
+		status, meta, err := parseSpartanHeader(test.header)
+		if (err == nil) != test.ok || status != test.status || meta != test.meta {
+			t.Errorf("parseSpartanHeader(%q) = %d, %q, %v, want %d, %q", test.header, status, meta, err, test.status, test.meta)
+		}
+	}
+}
+
+func TestSpartanRequest(t *testing.T) {
+	var tests = []struct {
+		u    string
+		size int64
+		res  string
+		data string
+	}{
+		{"spartan://example.org", 0, "example.org / 0\r\n", ""},
+		{"spartan://example.org:3000/a%20b?x", 1, "example.org /a%20b 1\r\n", "x"},
+		{"spartan://example.org/add?1+1%3D2", 5, "example.org /add 5\r\n", "1+1=2"},
+	}
+
+	for _, test := range tests {
+		u := mustParse(test.u)
+		if res := spartanRequest(u, test.size); res != test.res {
+			t.Errorf("spartanRequest(%q) = %q, want %q", test.u, res, test.res)
+		}
+		if data, err := spartanQuery(u); err != nil || string(data) != test.data {
+			t.Errorf("spartanQuery(%q) = %q, %v, want %q", test.u, data, err, test.data)
+		}
+	}
+	if _, err := spartanQuery(mustParse("spartan://example.org/?%zz")); err == nil {
+		t.Error("spartanQuery() with an invalid escape returned no error")
+	}
+}
+
+func TestSpartanParsedURL(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		request, _ := r.ReadString('\n')
+		data := make([]byte, 13)
+		io.ReadFull(r, data)
+		conn.Write([]byte("2 text/plain\r\n" + request + string(data)))
+	}()
+
+	u := mustParse("spartan://" + ln.Addr().String() + "/post")
+	res, err := SpartanParsedURL(u, strings.NewReader("line 1\nline 2 and more"), 13, &Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer (*res.conn).Close()
+	if res.status != 2 || res.meta != "text/plain" {
+		t.Errorf("header = %d %q, want 2 text/plain", res.status, res.meta)
+	}
+	body, _ := ioutil.ReadAll(res.bodyReader)
+	if want := "127.0.0.1 /post 13\r\nline 1\nline 2"; string(body) != want {
+		t.Errorf("request received = %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,141 @@
+// Gemini status codes, their names, and what gelim does about them
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type statusInfo struct {
+	name        string
+	description string // Shown when the server did not send a message
+}
+
+// geminiStatuses are the status codes defined by the gemini spec
+var geminiStatuses = map[int]statusInfo{
+	10: {"INPUT", "The page asks for input"},
+	11: {"SENSITIVE INPUT", "The page asks for sensitive input, such as a password"},
+	20: {"SUCCESS", ""},
+	30: {"TEMPORARY REDIRECT", "The page is at another URL for now"},
+	31: {"PERMANENT REDIRECT", "The page has moved to another URL"},
+	40: {"TEMPORARY FAILURE", "The request failed, try again later"},
+	41: {"SERVER UNAVAILABLE", "The server is overloaded or down for maintenance"},
+	42: {"CGI ERROR", "The program generating the page failed"},
+	43: {"PROXY ERROR", "The proxy was unable to fetch the page"},
+	44: {"SLOW DOWN", "Too many requests were made to the server"},
+	50: {"PERMANENT FAILURE", "The request failed, and will fail again"},
+	51: {"NOT FOUND", "There is no page at this URL"},
+	52: {"GONE", "The page was removed and will not come back"},
+	53: {"PROXY REQUEST REFUSED", "The server does not serve this host and refused to proxy the request"},
+	59: {"BAD REQUEST", "The server could not understand the request"},
+	60: {"CLIENT CERTIFICATE REQUIRED", "The page requires an identity"},
+	61: {"CERTIFICATE NOT AUTHORISED", "The identity used is not allowed to see this page"},
+	62: {"CERTIFICATE NOT VALID", "The identity used was not accepted"},
+}
+
+// Times to wait and try again when a server responds with 44 SLOW DOWN
+const maxSlowDownRetries = 3
+
+// Most seconds to wait before trying again, however long the server asks for
+const maxSlowDownWait = 60
+
+// geminiStatusDefined returns whether status is defined by the gemini spec
+func geminiStatusDefined(status int) bool {
+	_, ok := geminiStatuses[status]
+	return ok
+}
+
+// statusInfoFor returns the name and description of a gemini status code.
+// Undefined codes are described by the x0 code of their group, and codes of
+// other protocols, which are single digits, have no name.
+func statusInfoFor(code int) statusInfo {
+	if info, ok := geminiStatuses[code]; ok {
+		return info
+	}
+	if code < 10 {
+		return statusInfo{}
+	}
+	return geminiStatuses[code/10*10]
+}
+
+// PrintStatus takes the status code and the message and prints a colored message
+func (s *Style) PrintStatus(code int, msg string) {
+	info := statusInfoFor(code)
+	label := strconv.Itoa(code)
+	if info.name != "" {
+		label += " " + info.name
+	}
+	if msg == "" {
+		msg = info.description
+	}
+	fmt.Printf("[%s] %s\n", s.StyleSprint(s.StatusError, label), msg)
+}
+
+// fetch fetches u with protocol. If the server asks to slow down, it waits for
+// as many seconds as asked, up to maxSlowDownWait, and tries again, until the
+// user presses Ctrl-C.
+func (c *Client) fetch(protocol Protocol, u *url.URL) (*Response, error) {
+	for retries := 0; ; retries++ {
+		res, err := c.interruptible(func() (*Response, error) {
+			return protocol.Fetch(c, u)
+		})
+		if err != nil || res.status != StatusFailure || res.code != 44 || retries == maxSlowDownRetries {
+			return res, err
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(res.meta))
+		if err != nil || seconds < 1 {
+			seconds = 1
+		} else if seconds > maxSlowDownWait {
+			seconds = maxSlowDownWait
+		}
+		fmt.Printf("The server asks to slow down, trying again in %d seconds, press Ctrl-C to cancel\n", seconds)
+		if !waitOrInterrupt(time.Duration(seconds) * time.Second) {
+			c.style.WarningMsg("Cancelled")
+			return res, nil
+		}
+	}
+}
+
+// suggestSimilar prints the pages in the history that may be where the page
+// at u, which was not found, has moved to
+func (c *Client) suggestSimilar(u *url.URL) {
+	similar := c.savedHistory.Similar(u, 5)
+	if len(similar) == 0 {
+		return
+	}
+	fmt.Println("Similar pages from your history:")
+	for _, entry := range similar {
+		if entry.Title != "" {
+			fmt.Printf("  %s (%s)\n", entry.URL, entry.Title)
+		} else {
+			fmt.Println(" ", entry.URL)
+		}
+	}
+}
+
+// offerBookmarkRemoval asks whether to remove the bookmark of the page at u,
+// which is gone, if it is bookmarked
+func (c *Client) offerBookmarkRemoval(u *url.URL) {
+	b, ok := c.loadBookmarks()
+	if !ok {
+		return
+	}
+	i := b.Find(u.String())
+	if i == 0 {
+		return
+	}
+	fmt.Println("This page is bookmarked. Remove the bookmark?")
+	if opt, ok := c.PromptYesNo(); !ok || !opt {
+		return
+	}
+	b.Remove(i)
+	if err := b.Save(); err != nil {
+		c.style.ErrorMsg("Unable to save bookmarks: " + err.Error())
+		return
+	}
+	fmt.Println("Bookmark removed")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStatusInfoFor(t *testing.T) {
+	var tests = []struct {
+		code int
+		name string
+	}{
+		{20, "SUCCESS"},
+		{44, "SLOW DOWN"},
+		{53, "PROXY REQUEST REFUSED"},
+		{62, "CERTIFICATE NOT VALID"},
+		{45, "TEMPORARY FAILURE"},
+		{57, "PERMANENT FAILURE"},
+		{4, ""},
+		{0, ""},
+	}
+
+	for _, test := range tests {
+		if info := statusInfoFor(test.code); info.name != test.name {
+			t.Errorf("statusInfoFor(%d) = %q, want %q", test.code, info.name, test.name)
+		}
+	}
+}
+
+func TestGeminiStatusDefined(t *testing.T) {
+	var tests = []struct {
+		status int
+		res    bool
+	}{
+		{20, true},
+		{31, true},
+		{44, true},
+		{59, true},
+		{62, true},
+		{12, false},
+		{21, false},
+		{45, false},
+		{63, false},
+	}
+
+	for _, test := range tests {
+		if res := geminiStatusDefined(test.status); res != test.res {
+			t.Errorf("geminiStatusDefined(%d) = %v, want %v", test.status, res, test.res)
+		}
+	}
+}
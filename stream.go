@@ -0,0 +1,307 @@
+// Incremental rendering of response bodies
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manifoldco/ansiwrap"
+	"golang.org/x/term"
+)
+
+var (
+	ErrBodyTooLarge = errors.New("response body is larger than maxBodySize")
+	ErrInterrupted  = errors.New("interrupted")
+)
+
+// At most how many lines are held back before deciding how to center a page
+// that is being streamed. They are written sooner if no more of the page has
+// arrived yet. Later lines use the same indentation.
+const centerLookahead = 20
+
+// renderedLine is a single line of a document, rendered for display
+type renderedLine struct {
+	text   string
+	width  int // columns taken up by the line, for centering
+	dedent int // columns the line may hang to the left of centered content
+	links  int // number of links added to c.links by the line
+}
+
+// lineRenderer renders a line of a document (without the trailing newline).
+// ok is false for lines that should not be displayed.
+type lineRenderer func(line string) (rendered renderedLine, ok bool)
+
+// plainRenderer returns a lineRenderer for text/plain documents, which keeps
+// lines as they are, except for wrapping those wider than the terminal
+func (c *Client) plainRenderer() lineRenderer {
+	termWidth, _, err := term.GetSize(0)
+	if err != nil {
+		termWidth = 0
+	}
+	return func(line string) (renderedLine, bool) {
+		line = strings.TrimSuffix(line, "\r")
+		if termWidth <= 0 || len(line) <= termWidth {
+			return renderedLine{text: line, width: len(line)}, true
+		}
+		line = ansiwrap.Wrap(line, termWidth)
+		width := 0
+		for _, l := range strings.Split(line, "\n") {
+			if len(l) > width {
+				width = len(l)
+			}
+		}
+		return renderedLine{text: line, width: width}, true
+	}
+}
+
+// limitedReader reads from r until limit bytes are read, after which
+// ErrBodyTooLarge is returned if r has more to read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	if l.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// Reading one more byte than the limit tells whether there is more
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err = l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, ErrBodyTooLarge
+	}
+	l.remaining -= int64(n)
+	return
+}
+
+// cancelLoading closes body when the pager exits, until the returned stop
+// function is called. Ctrl-C is meant for the pager while it runs, so it is
+// ignored, unless there is no pager (exited is nil), in which case it closes
+// body. stop returns whether body was closed.
+func cancelLoading(body io.Closer, exited <-chan struct{}) (stop func() bool) {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	cancelled := make(chan bool, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		for {
+			select {
+			case <-sig:
+				if exited != nil {
+					continue
+				}
+			case <-exited:
+			case <-done:
+				cancelled <- false
+				return
+			}
+			body.Close()
+			cancelled <- true
+			return
+		}
+	}()
+	return func() bool {
+		signal.Stop(sig)
+		close(done)
+		return <-cancelled
+	}
+}
+
+// interruptible calls fetch with a dialer that is stopped by Ctrl-C, so that a
+// request can be cancelled before its response has arrived. If it was, the
+// body of the response is closed and ErrInterrupted is returned.
+func (c *Client) interruptible(fetch func() (*Response, error)) (*Response, error) {
+	dialer := c.dialer
+	d, stop := dialer.Interruptible()
+	c.dialer = d
+	res, err := fetch()
+	c.dialer = dialer
+	if stop() {
+		if err == nil && res.body != nil {
+			res.body.Close()
+		}
+		return nil, ErrInterrupted
+	}
+	return res, err
+}
+
+// waitOrInterrupt waits for d, and returns false if the user pressed Ctrl-C
+// before then
+func waitOrInterrupt(d time.Duration) bool {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	select {
+	case <-sig:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// readCloser reads a response body and closes the connection it is read from
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// PagerWriter starts `less` to display what is written to w, starting at
+// line startLine if it is positive. Close w once everything is written, then
+// call wait for the user to quit the pager. exited is closed when the pager
+// exits. Falls back to writing to stdout if less could not be started, with a
+// nil exited.
+func PagerWriter(conf *Config, startLine int) (w io.WriteCloser, wait func(), exited <-chan struct{}) {
+	cmd := exec.Command("less")
+	if startLine > 0 {
+		cmd.Args = append(cmd.Args, "+"+strconv.Itoa(startLine))
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nopWriteCloser{os.Stdout}, func() {}, nil
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "LESS="+conf.LessOpts)
+	if err := cmd.Start(); err != nil {
+		return nopWriteCloser{os.Stdout}, func() {}, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	return stdin, func() { <-done }, done
+}
+
+// streamPage renders the body of page line by line into the pager as it is
+// read, then returns the rendered page. If page.body is set, it is read up
+// to maxBodySize into page.bodyBytes, and can be cancelled by quitting the
+// pager. Lines are centered when center is true.
+func (c *Client) streamPage(page *Page, render lineRenderer, center bool) (rendered string, err error) {
+	w, wait, exited := PagerWriter(c.conf, c.scrollTo)
+	if page.body == nil {
+		rendered, _, err = c.renderLines(bytes.NewReader(page.bodyBytes), w, render, center)
+		w.Close()
+		wait()
+		return
+	}
+
+	var buf bytes.Buffer
+	var body io.Reader = page.body
+	if c.conf.MaxBodySize > 0 {
+		body = &limitedReader{r: body, remaining: int64(c.conf.MaxBodySize) << 20}
+	}
+	stop := cancelLoading(page.body, exited)
+	rendered, complete, err := c.renderLines(io.TeeReader(body, &buf), w, render, center)
+	if stop() {
+		err = ErrInterrupted
+	}
+	page.body = nil
+	page.bodyBytes = buf.Bytes()
+	page.truncated = !complete || err != nil
+	w.Close()
+	wait()
+	return
+}
+
+// renderLines renders each line read from r into w. complete is false if w
+// was closed before everything was read.
+func (c *Client) renderLines(r io.Reader, w io.Writer, render lineRenderer, center bool) (rendered string, complete bool, err error) {
+	var out strings.Builder
+	// Lines held back until we know how to center them
+	var pending []renderedLine
+	indent, width, maxDedent := 0, 0, 0
+	centered := !center
+	lineNo := 1
+	c.linkLines = make([]int, len(c.links))
+
+	write := func(line renderedLine) error {
+		if indent > line.dedent {
+			pad := strings.Repeat(" ", indent-line.dedent)
+			line.text = pad + strings.ReplaceAll(line.text, "\n", "\n"+pad)
+		}
+		for i := 0; i < line.links; i++ {
+			c.linkLines = append(c.linkLines, lineNo)
+		}
+		lineNo += strings.Count(line.text, "\n") + 1
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(line.text)
+		_, err := io.WriteString(w, line.text+"\n")
+		return err
+	}
+	flush := func() error {
+		if !centered {
+			centered = true
+			if sides, ok := c.centerIndent(width, maxDedent); ok {
+				indent = sides
+			}
+		}
+		for _, line := range pending {
+			if err := write(line); err != nil {
+				return err
+			}
+		}
+		pending = nil
+		return nil
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr == nil || line != "" {
+			links := len(c.links)
+			rl, ok := render(strings.TrimSuffix(line, "\n"))
+			rl.links = len(c.links) - links
+			if ok && !centered {
+				pending = append(pending, rl)
+				if rl.width > width {
+					width = rl.width
+				}
+				if rl.dedent > maxDedent {
+					maxDedent = rl.dedent
+				}
+				// Wait for more lines only if they have already arrived, so
+				// that slow servers are displayed as they send
+				full := len(pending) >= centerLookahead || reader.Buffered() == 0
+				if full && flush() != nil {
+					// The pager was closed
+					return out.String(), false, nil
+				}
+			} else if ok && write(rl) != nil {
+				return out.String(), false, nil
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			break
+		}
+	}
+	if flush() != nil {
+		return out.String(), false, err
+	}
+	return out.String(), true, err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderLinesSlowStream(t *testing.T) {
+	c := &Client{style: &DefaultStyle}
+	r, server := io.Pipe()
+	pager, w := io.Pipe()
+	done := make(chan bool)
+	go func() {
+		_, complete, _ := c.renderLines(r, w, c.plainRenderer(), true)
+		w.Close()
+		done <- complete
+	}()
+
+	// The first line is displayed before the rest of the page arrives, even
+	// though it is centered
+	server.Write([]byte("first line\n"))
+	lines := bufio.NewReader(pager)
+	first := make(chan string)
+	go func() {
+		line, _ := lines.ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if strings.TrimSpace(line) != "first line" {
+			t.Errorf("first line = %q, want %q", line, "first line")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the first line was held back until the page is complete")
+	}
+
+	server.Write([]byte("second line\n"))
+	server.Close()
+	if line, _ := lines.ReadString('\n'); strings.TrimSpace(line) != "second line" {
+		t.Errorf("second line = %q, want %q", line, "second line")
+	}
+	if complete := <-done; !complete {
+		t.Error("renderLines() did not complete")
+	}
+}
@@ -0,0 +1,229 @@
+// Uploading to gemini capsules with the Titan protocol
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// splitTitanParams returns u without the Titan parameters (";key=value")
+// after its path, and the parameters
+func splitTitanParams(u url.URL) (url.URL, map[string]string) {
+	params := make(map[string]string)
+	parts := strings.Split(u.Path, ";")
+	u.Path, u.RawPath = parts[0], ""
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+	return u, params
+}
+
+// titanRequest returns the request line for uploading size bytes of
+// mediaType to u
+func titanRequest(u url.URL, size int64, mediaType string, token string) string {
+	u.RawQuery, u.Fragment = "", ""
+	request := u.String() + ";size=" + strconv.FormatInt(size, 10)
+	if mediaType != "" {
+		request += ";mime=" + mediaType
+	}
+	if token != "" {
+		request += ";token=" + queryEscape(token)
+	}
+	return request + "\r\n"
+}
+
+// TitanParsedURL uploads size bytes read from body to u and returns the
+// response, which is a Gemini response. A token in the parameters of u is
+// used if token is empty.
+func TitanParsedURL(u url.URL, body io.Reader, size int64, mediaType string, token string, cert tls.Certificate, hosts *KnownHosts, d *Dialer) (*GeminiResponse, error) {
+	u, params := splitTitanParams(u)
+	if token == "" {
+		token, _ = url.QueryUnescape(params["token"])
+	}
+	if mediaType == "" {
+		mediaType = params["mime"]
+	}
+	return geminiRequest(u, titanRequest(u, size, mediaType, token), &sizedReader{body, size, size}, cert, hosts, d)
+}
+
+// sizedReader reads the size bytes declared in a request from r, and fails if
+// r ends before then, such as a file that shrank since it was measured
+type sizedReader struct {
+	r         io.Reader
+	size      int64
+	remaining int64
+}
+
+func (s *sizedReader) Read(p []byte) (n int, err error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err = s.r.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		err = fmt.Errorf("only %d of the %d bytes to upload could be read", s.size-s.remaining, s.size)
+	}
+	return
+}
+
+// withScheme returns a copy of u with the scheme changed, without Titan
+// parameters. It is used to convert between gemini:// and titan:// URLs of
+// the same resource.
+func withScheme(u *url.URL, scheme string) *url.URL {
+	converted, _ := splitTitanParams(*u)
+	converted.Scheme = scheme
+	return &converted
+}
+
+// titanToken returns the token configured for the host of u
+func (c *Client) titanToken(u *url.URL) string {
+	if token, ok := c.conf.TitanTokens[u.Host]; ok {
+		return token
+	}
+	return c.conf.TitanTokens[u.Hostname()]
+}
+
+// Upload sends size bytes read from body to the titan:// or gemini:// URL
+// target, then follows the redirect returned by the server, which is usually
+// the uploaded page. It returns whether the upload was successful.
+func (c *Client) Upload(target *url.URL, body io.Reader, size int64, mediaType string) bool {
+	if target.Scheme != "titan" && target.Scheme != "gemini" {
+		c.style.ErrorMsg("Unable to upload to " + target.Scheme + " URLs, only titan:// and gemini://")
+		return false
+	}
+	titanURL := withScheme(target, "titan")
+	if target.Scheme == "titan" {
+		// Keep parameters such as the token
+		titanURL = target
+	}
+	geminiURL := withScheme(target, "gemini")
+	// Identities are usually set up for the gemini:// URLs of a capsule
+	cert := c.getClientCert(titanURL)
+	if cert.Certificate == nil {
+		cert = c.getClientCert(geminiURL)
+	}
+
+	token := c.titanToken(titanURL)
+	if _, params := splitTitanParams(*titanURL); params["token"] != "" {
+		token = ""
+	}
+
+	fmt.Printf("Uploading %s to %s\n", formatSize(size), geminiURL)
+	res, err := TitanParsedURL(*titanURL, body, size, mediaType, token, cert, c.knownHosts, c.dialer)
+	if err != nil {
+		var mismatch *CertMismatchError
+		if errors.As(err, &mismatch) {
+			c.CertMismatchWarning(mismatch)
+			return false
+		}
+		c.style.ErrorMsg("Unable to upload: " + err.Error())
+		return false
+	}
+	defer res.conn.Close()
+	if res.status/10 == 2 || res.status/10 == 3 {
+		c.cache.Remove(geminiURL.String())
+	}
+
+	switch res.status / 10 {
+	case 2:
+		fmt.Println("Uploaded")
+		mediaType, params, err := ParseMeta(res.meta)
+		if err != nil {
+			return true
+		}
+		c.links = make([]string, 0, 100)
+		c.inputLinks = make([]int, 0, 100)
+		c.DisplayPage(&Page{mediaType: mediaType, params: params, u: geminiURL, body: readCloser{res.bodyReader, res.conn}})
+		c.recordVisit(geminiURL)
+		return true
+	case 3:
+		fmt.Println("Uploaded")
+		dest, err := geminiURL.Parse(res.meta)
+		if err != nil {
+			c.style.ErrorMsg(fmt.Sprintf("Invalid redirect URL %q returned by server", res.meta))
+			return true
+		}
+		res.conn.Close()
+		c.HandleParsedURL(dest)
+		return true
+	case 1:
+		c.style.ErrorMsg("The server asked for input, which is not supported for uploads:")
+		fmt.Println(res.meta)
+	case 6:
+		c.style.ErrorMsg("The server requires a client certificate to upload:")
+		fmt.Println(res.meta)
+		fmt.Println("Use `identity use` on a page of this capsule to use an identity for it.")
+	default:
+		c.style.ErrorMsg(fmt.Sprintf("Upload failed: %d %s", res.status, res.meta))
+		if _, params := splitTitanParams(*titanURL); token == "" && params["token"] == "" {
+			fmt.Println("If the server requires a token, set it in the titanTokens table in your config.")
+		}
+	}
+	return false
+}
+
+// EditPage opens the body of page in $EDITOR, shows the changes, and uploads
+// the edited page to its titan:// URL once confirmed. The edited file is kept
+// until gelim exits if it was not uploaded.
+func (c *Client) EditPage(page *Page) bool {
+	dir, err := c.tempDir()
+	if err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	path := uniquePath(filepath.Join(dir, downloadFilename(page.u, page.mediaType)))
+	if err := ioutil.WriteFile(path, page.bodyBytes, 0600); err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	if err := c.OpenEditor(path); err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	edited, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.style.ErrorMsg(err.Error())
+		return false
+	}
+	diff := c.style.FormatDiff(string(page.bodyBytes), string(edited))
+	if diff == "" {
+		fmt.Println("No changes, not uploading")
+		os.Remove(path)
+		return false
+	}
+	Pager(diff, c.conf)
+
+	geminiURL := withScheme(page.u, "gemini")
+	if name, _, _ := c.identities.ActiveFor(geminiURL.String(), c.conf.Identities); name != "" {
+		fmt.Println("Uploading as identity", name)
+	} else if c.getClientCert(geminiURL).Certificate == nil {
+		c.style.WarningMsg("No identity is used for this page, uploading without a client certificate")
+	}
+	fmt.Println("Upload these changes?")
+	if opt, ok := c.PromptYesNo(); !ok || !opt {
+		fmt.Println("Not uploaded, your edits are kept in", path, "until gelim exits")
+		return false
+	}
+	if !c.Upload(page.u, bytes.NewReader(edited), int64(len(edited)), page.mediaType) {
+		fmt.Println("Your edits are kept in", path, "until gelim exits")
+		return false
+	}
+	os.Remove(path)
+	return true
+}
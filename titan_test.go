@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTitanRequest(t *testing.T) {
+	var tests = []struct {
+		u         string
+		size      int64
+		mediaType string
+		token     string
+		res       string
+	}{
+		{"titan://example.org/notes.gmi", 42, "text/gemini", "", "titan://example.org/notes.gmi;size=42;mime=text/gemini\r\n"},
+		{"titan://example.org:1966/a b.txt?q#f", 0, "", "s3cret pass", "titan://example.org:1966/a%20b.txt;size=0;token=s3cret%20pass\r\n"},
+		{"titan://example.org/x.png;size=1;mime=image/png;token=abc", 7, "image/png", "abc", "titan://example.org/x.png;size=7;mime=image/png;token=abc\r\n"},
+	}
+
+	for _, test := range tests {
+		u, _ := splitTitanParams(*mustParse(test.u))
+		res := titanRequest(u, test.size, test.mediaType, test.token)
+		if res != test.res {
+			t.Errorf("titanRequest(%q) = %q, want %q", test.u, res, test.res)
+		}
+	}
+}
+
+func TestSplitTitanParams(t *testing.T) {
+	u, params := splitTitanParams(*mustParse("titan://example.org/dir/page.gmi;mime=text/gemini;token=abc"))
+	if u.Path != "/dir/page.gmi" {
+		t.Errorf("path = %q, want /dir/page.gmi", u.Path)
+	}
+	if params["mime"] != "text/gemini" || params["token"] != "abc" {
+		t.Errorf("params = %v, want mime and token", params)
+	}
+	if g := withScheme(mustParse("titan://example.org/a.gmi;token=abc"), "gemini"); g.String() != "gemini://example.org/a.gmi" {
+		t.Errorf("withScheme() = %q, want gemini://example.org/a.gmi", g)
+	}
+}
+
+func TestSizedReader(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, &sizedReader{strings.NewReader("hello world"), 5, 5}); err != nil || buf.String() != "hello" {
+		t.Errorf("copied %q, %v, want %q", buf.String(), err, "hello")
+	}
+	buf.Reset()
+	if _, err := io.Copy(&buf, &sizedReader{strings.NewReader("hi"), 5, 5}); err == nil {
+		t.Errorf("copied %q from a short body without an error", buf.String())
+	}
+}
@@ -0,0 +1,183 @@
+// Trust-on-first-use certificate pinning for TLS based protocols
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KnownHost is a certificate pinned for a host
+type KnownHost struct {
+	host        string // hostname:port
+	fingerprint string // hex-encoded SHA-256 of the DER certificate
+	expiry      time.Time
+}
+
+// KnownHosts is the store of pinned certificates, saved in a known_hosts-like
+// file with one "host fingerprint expiry" entry per line.
+type KnownHosts struct {
+	path  string
+	hosts map[string]KnownHost
+	// Certificates seen on the last connection to a host which did not match
+	// its pinned certificate, so that the user can choose to trust them.
+	pending map[string]KnownHost
+	// Called with a message when a certificate pinned on first use could not
+	// be saved, if set
+	warn func(msg string)
+}
+
+// CertMismatchError is returned when a host presents a certificate different
+// from the one pinned for it
+type CertMismatchError struct {
+	Old KnownHost
+	New KnownHost
+}
+
+func (e *CertMismatchError) Error() string {
+	return "certificate for " + e.New.host + " does not match the pinned certificate"
+}
+
+// NewKnownHost returns the KnownHost entry for cert presented by host
+func NewKnownHost(host string, cert *x509.Certificate) KnownHost {
+	sum := sha256.Sum256(cert.Raw)
+	return KnownHost{
+		host:        host,
+		fingerprint: hex.EncodeToString(sum[:]),
+		expiry:      cert.NotAfter.UTC(),
+	}
+}
+
+// LoadKnownHosts reads the known hosts file at path. A missing file is not an
+// error, and lines that could not be parsed are skipped.
+func LoadKnownHosts(path string) (*KnownHosts, error) {
+	k := &KnownHosts{
+		path:    path,
+		hosts:   make(map[string]KnownHost),
+		pending: make(map[string]KnownHost),
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return k, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if entry, ok := parseKnownHost(scanner.Text()); ok {
+			k.hosts[entry.host] = entry
+		}
+	}
+	return k, scanner.Err()
+}
+
+func parseKnownHost(line string) (entry KnownHost, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+		return
+	}
+	expiry, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return
+	}
+	return KnownHost{host: fields[0], fingerprint: fields[1], expiry: expiry}, true
+}
+
+func (e KnownHost) String() string {
+	return fmt.Sprintf("%s %s %s", e.host, e.fingerprint, e.expiry.Format(time.RFC3339))
+}
+
+// Save writes all pinned certificates to the known hosts file
+func (k *KnownHosts) Save() error {
+	lines := make([]string, 0, len(k.hosts))
+	for _, entry := range k.hosts {
+		lines = append(lines, entry.String())
+	}
+	sort.Strings(lines)
+	contents := strings.Join(lines, "\n")
+	if contents != "" {
+		contents += "\n"
+	}
+	return ioutil.WriteFile(k.path, []byte(contents), 0644)
+}
+
+// Check verifies cert presented by host against the pinned certificate. Hosts
+// seen for the first time, and hosts whose pinned certificate has expired, get
+// cert pinned. A *CertMismatchError is returned if cert does not match.
+func (k *KnownHosts) Check(host string, cert *x509.Certificate) error {
+	return k.check(NewKnownHost(host, cert), time.Now())
+}
+
+func (k *KnownHosts) check(entry KnownHost, now time.Time) error {
+	old, ok := k.hosts[entry.host]
+	if ok && old.fingerprint == entry.fingerprint {
+		return nil
+	}
+	if ok && now.Before(old.expiry) {
+		k.pending[entry.host] = entry
+		return &CertMismatchError{Old: old, New: entry}
+	}
+	k.hosts[entry.host] = entry
+	// The pin is kept for this session even if it could not be saved
+	if err := k.Save(); err != nil && k.warn != nil {
+		k.warn("Unable to save the certificate pinned for " + entry.host + ": " + err.Error())
+	}
+	return nil
+}
+
+// Trust pins the certificate that was last rejected for host
+func (k *KnownHosts) Trust(host string) bool {
+	entry, ok := k.pending[host]
+	if !ok {
+		return false
+	}
+	delete(k.pending, host)
+	k.hosts[host] = entry
+	return true
+}
+
+// Forget removes the pinned certificate for host
+func (k *KnownHosts) Forget(host string) bool {
+	if _, ok := k.hosts[host]; !ok {
+		return false
+	}
+	delete(k.hosts, host)
+	return true
+}
+
+// Sorted returns all pinned certificates sorted by host
+func (k *KnownHosts) Sorted() []KnownHost {
+	entries := make([]KnownHost, 0, len(k.hosts))
+	for _, entry := range k.hosts {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].host < entries[j].host })
+	return entries
+}
+
+// CertMismatchWarning explains a certificate mismatch to the user
+func (c *Client) CertMismatchWarning(e *CertMismatchError) {
+	c.style.ErrorMsg("The certificate presented by " + e.New.host + " does not match the one you trusted before!")
+	fmt.Println("This could mean that someone is intercepting your connection, or that the")
+	fmt.Println("server has changed its certificate before the old one expired.")
+	fmt.Println()
+	fmt.Println("Trusted certificate:")
+	fmt.Println("  fingerprint:", e.Old.fingerprint)
+	fmt.Println("  expires:    ", e.Old.expiry.Format(time.RFC1123))
+	fmt.Println("New certificate:")
+	fmt.Println("  fingerprint:", e.New.fingerprint)
+	fmt.Println("  expires:    ", e.New.expiry.Format(time.RFC1123))
+	fmt.Println()
+	fmt.Println("If you are sure the new certificate is genuine, use `certs trust " + e.New.host + "`")
+}
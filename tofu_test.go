@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKnownHostsCheck(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := now.AddDate(1, 0, 0)
+
+	k, err := LoadKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := KnownHost{"example.org:1965", "aaaa", later}
+	second := KnownHost{"example.org:1965", "bbbb", later}
+
+	if err := k.check(first, now); err != nil {
+		t.Errorf("check(first visit) = %v, want nil", err)
+	}
+	if err := k.check(first, now); err != nil {
+		t.Errorf("check(same certificate) = %v, want nil", err)
+	}
+
+	var mismatch *CertMismatchError
+	if err := k.check(second, now); !errors.As(err, &mismatch) {
+		t.Fatalf("check(changed certificate) = %v, want *CertMismatchError", err)
+	}
+	if mismatch.Old.fingerprint != "aaaa" || mismatch.New.fingerprint != "bbbb" {
+		t.Errorf("mismatch = %+v, want old aaaa and new bbbb", mismatch)
+	}
+
+	if !k.Trust("example.org:1965") {
+		t.Fatal("Trust() = false after a mismatch")
+	}
+	if err := k.check(second, now); err != nil {
+		t.Errorf("check(trusted certificate) = %v, want nil", err)
+	}
+
+	// The pinned certificate expired, so the new one replaces it
+	if err := k.check(first, later.Add(time.Hour)); err != nil {
+		t.Errorf("check(after expiry) = %v, want nil", err)
+	}
+
+	reloaded, err := LoadKnownHosts(k.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.hosts["example.org:1965"].fingerprint; got != "aaaa" {
+		t.Errorf("reloaded fingerprint = %q, want %q", got, "aaaa")
+	}
+}
+
+func TestParseKnownHost(t *testing.T) {
+	var tests = []struct {
+		line string
+		ok   bool
+	}{
+		{"example.org:1965 abcd 2030-01-02T03:04:05Z", true},
+		{"[::1]:1965 abcd 2030-01-02T03:04:05Z", true},
+		{"example.org:1965 abcd", false},
+		{"example.org:1965 abcd yesterday", false},
+		{"# example.org:1965 abcd 2030-01-02T03:04:05Z", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		entry, ok := parseKnownHost(test.line)
+		if ok != test.ok {
+			t.Errorf("parseKnownHost(%q) ok = %v, want %v", test.line, ok, test.ok)
+		}
+		if ok && entry.String() != test.line {
+			t.Errorf("parseKnownHost(%q).String() = %q", test.line, entry.String())
+		}
+	}
+}
+
+func TestKnownHostsCheckUnsaved(t *testing.T) {
+	// The parent directory does not exist, so the pin cannot be saved
+	k, err := LoadKnownHosts(filepath.Join(t.TempDir(), "missing", "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warning string
+	k.warn = func(msg string) { warning = msg }
+	entry := KnownHost{"example.org:1965", "aaaa", time.Now().AddDate(1, 0, 0)}
+	if err := k.check(entry, time.Now()); err != nil {
+		t.Errorf("check(first visit) = %v, want nil", err)
+	}
+	if warning == "" {
+		t.Error("no warning for a pin that could not be saved")
+	}
+	if _, ok := k.hosts["example.org:1965"]; !ok {
+		t.Error("the certificate was not pinned for the session")
+	}
+}